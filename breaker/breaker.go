@@ -0,0 +1,333 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package breaker implements the circuit breaker pattern.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
+	"github.com/wokaio/fdlib/metric"
+)
+
+// ErrOpen is returned for requests rejected by the breaker, because it is
+// open or already has as many half-open probes in flight as allowed.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// StateClosed lets all requests through while tracking their outcome.
+	StateClosed State = iota
+	// StateOpen rejects all requests until the cool-down has elapsed.
+	StateOpen
+	// StateHalfOpen lets a limited number of probe requests through to decide
+	// whether to close again.
+	StateHalfOpen
+)
+
+// String returns the name of the state, as published to the state metric.
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Config contains the settings of a Breaker. Zero fields take their defaults.
+type Config struct {
+	Window       time.Duration // length of the rolling window of outcomes, defaults to 10s
+	Buckets      int           // number of buckets the window advances by, defaults to 10
+	FailureRatio float64       // failure ratio in the window which opens the breaker, defaults to 0.5
+	MinRequests  int           // requests in the window before the ratio is checked, defaults to 10
+	CoolDown     time.Duration // time spent open before probing, defaults to 5s
+	// HalfOpenMaxProbes is the number of probes let through while half-open,
+	// defaults to 1. The breaker closes once all of them succeeded and opens
+	// again on the first failure.
+	HalfOpenMaxProbes int
+	// IsFailure decides whether an error counts as a failure. If nil, every
+	// non-nil error does.
+	IsFailure func(err error) bool
+	Clock     kmclock.Clock // defaults to kmclock.System
+	// Metrics receives the breaker state and counters. It may be a struct
+	// registered with metric.NewMetricStats, otherwise the breaker allocates
+	// its own.
+	Metrics *Metrics
+}
+
+// Metrics are the state and counters maintained by a Breaker.
+type Metrics struct {
+	State      *metric.StateNumber   // name of the current state
+	Successes  *metric.CounterNumber // requests which succeeded
+	Failures   *metric.CounterNumber // requests which failed
+	Rejections *metric.CounterNumber // requests rejected without being attempted
+}
+
+// bucket counts the outcomes within a slice of the window.
+type bucket struct {
+	requests, failures int
+}
+
+// Breaker stops sending requests to a failing dependency. While closed, it
+// tracks the outcome of the requests over a rolling window, and opens when
+// the ratio of failures crosses the threshold. After the cool-down it turns
+// half-open and lets a few probe requests through, which close it when they
+// succeed and open it again otherwise.
+type Breaker struct {
+	lock       sync.Mutex
+	config     Config
+	metrics    *Metrics
+	state      State
+	generation uint64 // incremented on state changes, to ignore stale outcomes
+	timer      kmclock.Timer
+
+	origin  kmclock.AbsTime // start of the first bucket
+	span    time.Duration   // time covered by a bucket
+	buckets []bucket
+	tick    int64 // index of the current bucket since origin
+
+	probes    int // probes let through in the current half-open state
+	succeeded int // probes which succeeded
+}
+
+// New creates a closed breaker.
+func New(config *Config) *Breaker {
+	b := new(Breaker)
+	if config != nil {
+		b.config = *config
+	}
+	if b.config.Window <= 0 {
+		b.config.Window = 10 * time.Second
+	}
+	if b.config.Buckets <= 0 {
+		b.config.Buckets = 10
+	}
+	if b.config.FailureRatio <= 0 {
+		b.config.FailureRatio = 0.5
+	}
+	if b.config.MinRequests <= 0 {
+		b.config.MinRequests = 10
+	}
+	if b.config.CoolDown <= 0 {
+		b.config.CoolDown = 5 * time.Second
+	}
+	if b.config.HalfOpenMaxProbes <= 0 {
+		b.config.HalfOpenMaxProbes = 1
+	}
+	if b.config.Clock == nil {
+		b.config.Clock = kmclock.System{}
+	}
+
+	b.metrics = b.config.Metrics
+	if b.metrics == nil {
+		b.metrics = new(Metrics)
+	}
+	if b.metrics.State == nil {
+		b.metrics.State = new(metric.StateNumber)
+	}
+	if b.metrics.Successes == nil {
+		b.metrics.Successes = new(metric.CounterNumber)
+	}
+	if b.metrics.Failures == nil {
+		b.metrics.Failures = new(metric.CounterNumber)
+	}
+	if b.metrics.Rejections == nil {
+		b.metrics.Rejections = new(metric.CounterNumber)
+	}
+
+	b.span = b.config.Window / time.Duration(b.config.Buckets)
+	if b.span <= 0 {
+		b.span = 1
+	}
+	b.buckets = make([]bucket, b.config.Buckets)
+	b.origin = b.config.Clock.Now()
+	b.metrics.State.Set(b.state.String())
+	return b
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
+
+// Metrics returns the state and counters of the breaker.
+func (b *Breaker) Metrics() *Metrics {
+	return b.metrics
+}
+
+// Counts returns the number of requests and failures in the rolling window.
+func (b *Breaker) Counts() (requests, failures int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.advance(b.config.Clock.Now())
+	return b.counts()
+}
+
+// Allow asks whether a request may be attempted. If so, the caller must
+// report its outcome by calling done with the request's error. Otherwise
+// ErrOpen is returned.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		b.metrics.Rejections.Inc(1)
+		return nil, ErrOpen
+	case StateHalfOpen:
+		if b.probes >= b.config.HalfOpenMaxProbes {
+			b.metrics.Rejections.Inc(1)
+			return nil, ErrOpen
+		}
+		b.probes++
+	}
+	generation := b.generation
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { b.done(generation, err) })
+	}, nil
+}
+
+// Execute runs fn if the breaker allows it and records its outcome. It returns
+// ErrOpen if the request was rejected, otherwise the error of fn. A panic in
+// fn counts as a failure and is propagated.
+func (b *Breaker) Execute(fn func() error) (err error) {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			done(errPanic)
+			panic(r)
+		}
+	}()
+	err = fn()
+	done(err)
+	return err
+}
+
+// errPanic is recorded for requests which panicked.
+var errPanic = errors.New("panic")
+
+// done records the outcome of a request allowed in the given generation.
+func (b *Breaker) done(generation uint64, err error) {
+	failed := err != nil
+	if failed && b.config.IsFailure != nil && err != errPanic {
+		failed = b.config.IsFailure(err)
+	}
+	if failed {
+		b.metrics.Failures.Inc(1)
+	} else {
+		b.metrics.Successes.Inc(1)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if generation != b.generation {
+		return // the state changed since the request was allowed
+	}
+	now := b.config.Clock.Now()
+	switch b.state {
+	case StateClosed:
+		b.advance(now)
+		cur := &b.buckets[b.tick%int64(len(b.buckets))]
+		cur.requests++
+		if failed {
+			cur.failures++
+			requests, failures := b.counts()
+			if requests >= b.config.MinRequests && float64(failures) >= b.config.FailureRatio*float64(requests) {
+				b.open()
+			}
+		}
+	case StateHalfOpen:
+		if failed {
+			b.open()
+			return
+		}
+		if b.succeeded++; b.succeeded >= b.config.HalfOpenMaxProbes {
+			b.setState(StateClosed)
+			b.origin, b.tick = now, 0
+			for i := range b.buckets {
+				b.buckets[i] = bucket{}
+			}
+		}
+	}
+}
+
+// open opens the breaker and schedules the transition to half-open. It must
+// be called with b.lock held.
+func (b *Breaker) open() {
+	b.setState(StateOpen)
+	generation := b.generation
+	b.timer = b.config.Clock.AfterFunc(b.config.CoolDown, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+
+		if b.generation == generation {
+			b.setState(StateHalfOpen)
+			b.probes, b.succeeded = 0, 0
+		}
+	})
+}
+
+// setState switches to state and publishes it. It must be called with b.lock
+// held.
+func (b *Breaker) setState(state State) {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.state = state
+	b.generation++
+	b.metrics.State.Set(state.String())
+}
+
+// advance moves the window forward to now, clearing the buckets which fell out
+// of it. It must be called with b.lock held.
+func (b *Breaker) advance(now kmclock.AbsTime) {
+	tick := int64(now.Sub(b.origin) / b.span)
+	if tick <= b.tick {
+		return
+	}
+	n := len(b.buckets)
+	for t := b.tick + 1; t <= tick && t <= b.tick+int64(n); t++ {
+		b.buckets[t%int64(n)] = bucket{}
+	}
+	b.tick = tick
+}
+
+// counts sums up the buckets of the window. It must be called with b.lock
+// held.
+func (b *Breaker) counts() (requests, failures int) {
+	for _, bucket := range b.buckets {
+		requests += bucket.requests
+		failures += bucket.failures
+	}
+	return requests, failures
+}
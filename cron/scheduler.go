@@ -0,0 +1,303 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cron
+
+import (
+	"log"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
+)
+
+// OverlapPolicy decides what happens when a job is due while its previous run
+// is still in progress.
+type OverlapPolicy int
+
+const (
+	// OverlapAllow starts the new run concurrently.
+	OverlapAllow OverlapPolicy = iota
+	// OverlapSkip drops the new run.
+	OverlapSkip
+	// OverlapQueue starts the new run once the current one has finished.
+	OverlapQueue
+)
+
+// JobConfig contains the settings of a scheduled job.
+type JobConfig struct {
+	// Jitter is the upper bound of a random delay added to every run. It must
+	// be shorter than the gap between activations: the next activation is
+	// computed from the delayed run, so with a longer jitter the activations
+	// passed meanwhile are treated as missed, see MaxCatchUp.
+	Jitter  time.Duration
+	Overlap OverlapPolicy
+	// MaxCatchUp is the number of missed activations run in addition to the
+	// due one when the scheduler fell behind, e.g. after the process was
+	// suspended. With 0, missed activations are coalesced into a single run.
+	MaxCatchUp int
+}
+
+// JobID identifies a job added to a Scheduler.
+type JobID uint64
+
+// job is a function registered on a Scheduler.
+type job struct {
+	id       JobID
+	schedule Schedule
+	fn       func()
+	config   JobConfig
+
+	next    time.Time     // next activation, zero if there is none
+	timer   kmclock.Timer // wakes the scheduler up for next
+	gen     uint64        // incremented to invalidate callbacks of old timers
+	active  int           // number of runs in progress
+	queued  int           // runs waiting for the active one, with OverlapQueue
+	removed bool
+}
+
+// Scheduler runs jobs on cron schedules. All timing goes through the injected
+// clock, so schedules can be driven deterministically by kmclock.Simulated
+// with its epoch set to the wall time of interest.
+//
+// Every job has its own timer on the clock. Jobs run on their own goroutine,
+// and a panicking job doesn't stop the scheduler, see SetPanicHandler.
+type Scheduler struct {
+	clock    kmclock.Clock
+	location *time.Location
+	onPanic  func(id JobID, recovered interface{})
+
+	lock    sync.Mutex
+	jobs    map[JobID]*job
+	lastID  JobID
+	running bool
+	wg      sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler driven by clock which evaluates schedules
+// in location, defaulting to time.Local. Schedules parsed with a TZ prefix use
+// their own location instead.
+func NewScheduler(clock kmclock.Clock, location *time.Location) *Scheduler {
+	if location == nil {
+		location = time.Local
+	}
+	return &Scheduler{
+		clock:    clock,
+		location: location,
+		jobs:     make(map[JobID]*job),
+	}
+}
+
+// SetPanicHandler sets the function called with the value recovered from a
+// panicking job. By default, the panic is logged with its stack trace.
+func (s *Scheduler) SetPanicHandler(handler func(id JobID, recovered interface{})) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.onPanic = handler
+}
+
+// Add parses spec and schedules fn on it. See Parse for the accepted formats.
+func (s *Scheduler) Add(spec string, fn func(), config *JobConfig) (JobID, error) {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return 0, err
+	}
+	return s.AddSchedule(schedule, fn, config), nil
+}
+
+// AddSchedule schedules fn on schedule. If the scheduler is running, the job is
+// armed right away, otherwise on Start.
+func (s *Scheduler) AddSchedule(schedule Schedule, fn func(), config *JobConfig) JobID {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastID++
+	j := &job{id: s.lastID, schedule: schedule, fn: fn}
+	if config != nil {
+		j.config = *config
+	}
+	s.jobs[j.id] = j
+	if s.running {
+		s.arm(j, s.now())
+	}
+	return j.id
+}
+
+// Remove unschedules a job. Runs in progress are not interrupted, queued ones
+// are dropped. It returns false if there is no job with the given ID.
+func (s *Scheduler) Remove(id JobID) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return false
+	}
+	delete(s.jobs, id)
+	j.removed = true
+	j.queued = 0
+	j.gen++
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+	return true
+}
+
+// Next returns the next activation of a job. It returns false if there is no
+// job with the given ID or the scheduler isn't running.
+func (s *Scheduler) Next(id JobID) (time.Time, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok || !s.running {
+		return time.Time{}, false
+	}
+	return j.next, !j.next.IsZero()
+}
+
+// Start arms the timers of all jobs.
+func (s *Scheduler) Start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.running {
+		return
+	}
+	s.running = true
+	now := s.now()
+	for _, j := range s.jobs {
+		s.arm(j, now)
+	}
+}
+
+// Stop disarms all timers and waits for the runs in progress to finish. Queued
+// runs are dropped. The scheduler can be started again.
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	s.running = false
+	for _, j := range s.jobs {
+		j.queued = 0
+		j.gen++ // a callback already waiting for the lock must not fire
+		if j.timer != nil {
+			j.timer.Stop()
+			j.timer = nil
+		}
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+}
+
+// now returns the current wall time of the clock in the scheduler's location.
+func (s *Scheduler) now() time.Time {
+	return s.clock.Time().In(s.location)
+}
+
+// arm computes the next activation of j after now and sets its timer,
+// invalidating the callbacks of earlier timers. It must be called with s.lock
+// held.
+func (s *Scheduler) arm(j *job, now time.Time) {
+	j.gen++
+	gen := j.gen
+	j.next = j.schedule.Next(now)
+	if j.next.IsZero() {
+		j.timer = nil
+		return
+	}
+	delay := j.next.Sub(now)
+	if j.config.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(j.config.Jitter)))
+	}
+	j.timer = s.clock.AfterFunc(delay, func() { s.fire(j, gen) })
+}
+
+// fire is called by the timer of j armed in generation gen. It runs the due
+// activation plus as many missed ones as allowed and re-arms the timer.
+// Callbacks of timers stopped or replaced meanwhile are ignored.
+func (s *Scheduler) fire(j *job, gen uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if j.gen != gen || j.removed || !s.running || j.next.IsZero() {
+		return
+	}
+	now := s.now()
+	runs := 1
+	for t := j.schedule.Next(j.next); runs <= j.config.MaxCatchUp && !t.IsZero() && !t.After(now); t = j.schedule.Next(t) {
+		runs++
+	}
+	for i := 0; i < runs; i++ {
+		s.launch(j)
+	}
+	s.arm(j, now)
+}
+
+// launch starts a run of j according to its overlap policy. It must be called
+// with s.lock held.
+func (s *Scheduler) launch(j *job) {
+	if j.active > 0 {
+		switch j.config.Overlap {
+		case OverlapSkip:
+			return
+		case OverlapQueue:
+			j.queued++
+			return
+		}
+	}
+	j.active++
+	s.wg.Add(1)
+	go s.run(j)
+}
+
+// run executes j, followed by the runs queued meanwhile.
+func (s *Scheduler) run(j *job) {
+	defer s.wg.Done()
+
+	for {
+		s.call(j)
+
+		s.lock.Lock()
+		if j.queued > 0 {
+			j.queued--
+			s.lock.Unlock()
+			continue
+		}
+		j.active--
+		s.lock.Unlock()
+		return
+	}
+}
+
+// call runs j, recovering from a panic so the scheduler stays intact, and
+// reports the panic to the handler.
+func (s *Scheduler) call(j *job) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.lock.Lock()
+			handler := s.onPanic
+			s.lock.Unlock()
+			if handler != nil {
+				handler(j.id, r)
+			} else {
+				log.Printf("cron: job %d panicked: %v\n%s", j.id, r, debug.Stack())
+			}
+		}
+	}()
+	j.fn()
+}
@@ -0,0 +1,331 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule describes when a job runs.
+type Schedule interface {
+	// Next returns the first activation strictly after t, or the zero time if
+	// there is none.
+	Next(t time.Time) time.Time
+}
+
+// SpecSchedule is a schedule given by cron fields. Every field is a bit set of
+// the values it matches.
+type SpecSchedule struct {
+	Second, Minute, Hour, Dom, Month, Dow uint64
+
+	// DomAny and DowAny are set if the day-of-month or day-of-week field is a
+	// wildcard. If neither is, a day matches when either field matches.
+	DomAny, DowAny bool
+
+	// Location overrides the location of the times passed to Next.
+	Location *time.Location
+}
+
+// EverySchedule activates at every multiple of Every since the Unix epoch, so
+// "@every 15s" runs at :00, :15, :30 and :45 of every minute.
+type EverySchedule struct {
+	Every time.Duration
+}
+
+// bounds is the range of a cron field.
+type bounds struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	seconds = bounds{0, 59, nil}
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	doms    = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dows = bounds{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// Parse parses a schedule specification, which is one of
+//
+//   - five cron fields: minute, hour, day of month, month, day of week
+//   - six cron fields: the same, preceded by the second
+//   - a descriptor: @yearly, @annually, @monthly, @weekly, @daily, @midnight, @hourly
+//   - @every followed by a duration, e.g. "@every 1m30s"
+//
+// Fields accept *, ?, lists, ranges, steps and month or weekday names, where
+// both 0 and 7 mean Sunday. The specification may be prefixed with TZ=<zone>
+// or CRON_TZ=<zone> to evaluate it in that time zone.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	var loc *time.Location
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("cron: missing fields after time zone in %q", spec)
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("cron: invalid time zone %q: %v", name, err)
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("cron: invalid duration in %q: %v", spec, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("cron: non-positive duration in %q", spec)
+		}
+		return EverySchedule{Every: d}, nil
+	}
+	if strings.HasPrefix(spec, "@") {
+		fields, ok := descriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("cron: unknown descriptor %q", spec)
+		}
+		spec = fields
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron: expected 5 or 6 fields, found %d in %q", len(fields), spec)
+	}
+
+	s := &SpecSchedule{Location: loc}
+	var err error
+	if s.Second, _, err = parseField(fields[0], seconds); err != nil {
+		return nil, err
+	}
+	if s.Minute, _, err = parseField(fields[1], minutes); err != nil {
+		return nil, err
+	}
+	if s.Hour, _, err = parseField(fields[2], hours); err != nil {
+		return nil, err
+	}
+	if s.Dom, s.DomAny, err = parseField(fields[3], doms); err != nil {
+		return nil, err
+	}
+	if s.Month, _, err = parseField(fields[4], months); err != nil {
+		return nil, err
+	}
+	if s.Dow, s.DowAny, err = parseField(fields[5], dows); err != nil {
+		return nil, err
+	}
+	if s.Dow&(1<<7) != 0 {
+		s.Dow = s.Dow&^(1<<7) | 1 // 7 is an alias of Sunday
+	}
+	return s, nil
+}
+
+// parseField parses a comma separated cron field into a bit set, reporting
+// whether it is a wildcard.
+func parseField(field string, b bounds) (uint64, bool, error) {
+	var bits uint64
+	for _, expr := range strings.Split(field, ",") {
+		if expr == "*" || expr == "?" {
+			return bits | span(b.min, b.max, 1), true, nil
+		}
+		rangeExpr, step := expr, uint(1)
+		if i := strings.Index(expr, "/"); i >= 0 {
+			n, err := strconv.ParseUint(expr[i+1:], 10, 8)
+			if err != nil || n == 0 {
+				return 0, false, fmt.Errorf("cron: invalid step in %q", expr)
+			}
+			rangeExpr, step = expr[:i], uint(n)
+		}
+
+		var lo, hi uint
+		switch {
+		case rangeExpr == "*" || rangeExpr == "?":
+			lo, hi = b.min, b.max
+		case strings.Contains(rangeExpr, "-"):
+			parts := strings.SplitN(rangeExpr, "-", 2)
+			var err error
+			if lo, err = parseValue(parts[0], b); err != nil {
+				return 0, false, err
+			}
+			if hi, err = parseValue(parts[1], b); err != nil {
+				return 0, false, err
+			}
+		default:
+			v, err := parseValue(rangeExpr, b)
+			if err != nil {
+				return 0, false, err
+			}
+			lo, hi = v, v
+			if step > 1 {
+				hi = b.max // "a/n" means from a to the end
+			}
+		}
+		if lo > hi {
+			return 0, false, fmt.Errorf("cron: range %q is backwards", expr)
+		}
+		bits |= span(lo, hi, step)
+	}
+	return bits, false, nil
+}
+
+// parseValue parses a single number or name of a field.
+func parseValue(s string, b bounds) (uint, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("cron: invalid value %q", s)
+	}
+	if uint(v) < b.min || uint(v) > b.max {
+		return 0, fmt.Errorf("cron: value %d out of range [%d, %d]", v, b.min, b.max)
+	}
+	return uint(v), nil
+}
+
+// span returns the bits from lo to hi in increments of step.
+func span(lo, hi, step uint) uint64 {
+	var bits uint64
+	for v := lo; v <= hi; v += step {
+		bits |= 1 << v
+	}
+	return bits
+}
+
+// Next returns the first time after t matching the schedule, or the zero time
+// if there is none within the next five years.
+func (s *SpecSchedule) Next(t time.Time) time.Time {
+	orig := t.Location()
+	loc := orig
+	if s.Location != nil {
+		loc = s.Location
+		t = t.In(loc)
+	}
+
+	// Start at the next whole second.
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	added := false // whether a field has been moved, resetting the lower ones
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for 1<<uint(t.Month())&s.Month == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		// Midnight may not exist on DST transition days, shift back to it.
+		if h := t.Hour(); h != 0 {
+			if h > 12 {
+				t = t.Add(time.Duration(24-h) * time.Hour)
+			} else {
+				t = t.Add(-time.Duration(h) * time.Hour)
+			}
+		}
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for 1<<uint(t.Hour())&s.Hour == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for 1<<uint(t.Minute())&s.Minute == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	for 1<<uint(t.Second())&s.Second == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+	return t.In(orig)
+}
+
+// dayMatches reports whether the day of t matches the day-of-month and
+// day-of-week fields.
+func (s *SpecSchedule) dayMatches(t time.Time) bool {
+	dom := 1<<uint(t.Day())&s.Dom != 0
+	dow := 1<<uint(t.Weekday())&s.Dow != 0
+	if s.DomAny || s.DowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first multiple of the interval since the Unix epoch after t.
+func (s EverySchedule) Next(t time.Time) time.Time {
+	every := int64(s.Every)
+	now := t.UnixNano()
+	next := now - now%every + every
+	if now < 0 && now%every != 0 {
+		next -= every
+	}
+	return time.Unix(0, next).In(t.Location())
+}
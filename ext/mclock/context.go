@@ -0,0 +1,113 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kmclock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// clockCtx is a context which is canceled by a timer of a Clock rather than
+// the runtime's timers.
+type clockCtx struct {
+	context.Context // parent, provides Value
+
+	deadline time.Time
+	done     chan struct{}
+	mu       sync.Mutex
+	err      error
+	timer    Timer
+}
+
+// WithTimeout is like context.WithTimeout, but the timeout elapses on clock.
+// With a Simulated clock, the context expires when Run advances the clock
+// past the timeout.
+func WithTimeout(parent context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	return WithDeadline(parent, clock, clock.Now().Add(d))
+}
+
+// WithDeadline is like context.WithDeadline, but the deadline is a time of
+// clock. The Deadline method of the returned context reports it converted to
+// the clock's wall time. A deadline of the parent is still enforced through
+// Done, but not reported by Deadline, since it is a time of the real clock.
+func WithDeadline(parent context.Context, clock Clock, deadline AbsTime) (context.Context, context.CancelFunc) {
+	d := deadline.Sub(clock.Now())
+	c := &clockCtx{
+		Context:  parent,
+		deadline: clock.Time().Add(d),
+		done:     make(chan struct{}),
+	}
+	cancel := func() { c.cancel(context.Canceled) }
+
+	if err := parent.Err(); err != nil {
+		c.cancel(err)
+		return c, cancel
+	}
+	if d <= 0 {
+		c.cancel(context.DeadlineExceeded)
+		return c, cancel
+	}
+	c.mu.Lock()
+	c.timer = clock.AfterFunc(d, func() { c.cancel(context.DeadlineExceeded) })
+	c.mu.Unlock()
+
+	if pdone := parent.Done(); pdone != nil {
+		go func() {
+			select {
+			case <-pdone:
+				c.cancel(parent.Err())
+			case <-c.done:
+			}
+		}()
+	}
+	return c, cancel
+}
+
+// Deadline returns the clock deadline as wall time of the clock. The parent's
+// deadline is on another timeline with a simulated clock, so it isn't compared.
+func (c *clockCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+// Done returns a channel which is closed when the context is canceled.
+func (c *clockCtx) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the context was canceled, or nil if it isn't yet.
+func (c *clockCtx) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// cancel closes the done channel with the given reason, unless the context is
+// already canceled.
+func (c *clockCtx) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
@@ -51,6 +51,11 @@ type Clock interface {
 	NewTimer(time.Duration) ChanTimer
 	After(time.Duration) <-chan AbsTime
 	AfterFunc(d time.Duration, f func()) Timer
+	NewTicker(time.Duration) Ticker
+
+	// Time returns the current wall-clock time. Unlike Now, it may jump when
+	// the system time is adjusted.
+	Time() time.Time
 }
 
 // Timer is a cancellable event created by AfterFunc.
@@ -71,6 +76,17 @@ type ChanTimer interface {
 	Reset(time.Duration)
 }
 
+// Ticker delivers ticks at a fixed period, created by NewTicker.
+type Ticker interface {
+	// The channel returned by C receives the clock time of every tick. Like
+	// with time.Ticker, ticks are dropped for slow receivers.
+	C() <-chan AbsTime
+	// Reset stops the ticker and restarts it with a new period.
+	Reset(time.Duration)
+	// Stop turns off the ticker. It doesn't close the channel.
+	Stop()
+}
+
 // System implements Clock using the system clock.
 type System struct{}
 
@@ -111,6 +127,16 @@ func (c System) AfterFunc(d time.Duration, f func()) Timer {
 	return time.AfterFunc(d, f)
 }
 
+// NewTicker returns a ticker with period d. It panics if d <= 0.
+func (c System) NewTicker(d time.Duration) Ticker {
+	return newTicker(c, d)
+}
+
+// Time returns the current system time.
+func (c System) Time() time.Time {
+	return time.Now()
+}
+
 type systemTimer struct {
 	*time.Timer
 	ch <-chan AbsTime
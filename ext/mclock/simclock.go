@@ -20,6 +20,7 @@ package kmclock
 
 import (
 	"container/heap"
+	"runtime"
 	"sync"
 	"time"
 )
@@ -35,14 +36,40 @@ import (
 // the timeout using a channel or semaphore.
 type Simulated struct {
 	now       AbsTime
+	epoch     time.Time // wall-clock time at virtual time zero
 	scheduled simTimerHeap
+	lastID    uint64 // ID of the most recently scheduled timer
+	version   uint64 // incremented on every change of the schedule
+	trace     func(SimEvent)
 	mu        sync.RWMutex
 	cond      *sync.Cond
 }
 
+// SimEventKind is the type of a SimEvent.
+type SimEventKind int
+
+const (
+	SimScheduled SimEventKind = iota // a timer was created or reset
+	SimStopped                       // a pending timer was stopped
+	SimFired                         // a timer fired
+)
+
+// SimEvent describes a change of the timers of a Simulated clock.
+type SimEvent struct {
+	Kind  SimEventKind
+	Timer uint64  // ID of the timer, assigned in creation order
+	Now   AbsTime // virtual time of the event
+	At    AbsTime // time the timer is scheduled for
+}
+
+// maxIdleEvents bounds the number of timers RunUntilIdle fires, to detect
+// timers which keep rescheduling themselves.
+const maxIdleEvents = 1000000
+
 // simTimer implements ChanTimer on the virtual clock.
 type simTimer struct {
 	at    AbsTime
+	id    uint64
 	index int // position in s.scheduled
 	s     *Simulated
 	do    func()
@@ -55,22 +82,142 @@ func (s *Simulated) init() {
 	}
 }
 
+// SetTrace installs a hook which is called for every timer that is scheduled,
+// stopped or fired, e.g. to log the course of a test. The hook is called
+// without holding the clock's lock, so it may use the clock.
+func (s *Simulated) SetTrace(trace func(SimEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trace = trace
+}
+
 // Run moves the clock by the given duration, executing all timers before that duration.
+// Timers fire one by one in order of their time, with the clock set to that time, and
+// timers scheduled by the callbacks are executed as well if they are due before the end.
 func (s *Simulated) Run(d time.Duration) {
 	s.mu.Lock()
-	s.init()
-
 	end := s.now + AbsTime(d)
-	var do []func()
-	for len(s.scheduled) > 0 && s.scheduled[0].at <= end {
-		ev := heap.Pop(&s.scheduled).(*simTimer)
-		do = append(do, ev.do)
+	s.mu.Unlock()
+
+	s.runUntil(end)
+}
+
+// AdvanceToNext moves the clock to the time of the earliest pending timer and
+// executes all timers due at that time. It returns false if there is no timer.
+func (s *Simulated) AdvanceToNext() bool {
+	s.mu.Lock()
+	if len(s.scheduled) == 0 {
+		s.mu.Unlock()
+		return false
 	}
-	s.now = end
+	at := s.scheduled[0].at
 	s.mu.Unlock()
 
-	for _, fn := range do {
-		fn()
+	s.runUntil(at)
+	return true
+}
+
+// RunUntilIdle advances the clock and executes timers until none is left, and
+// returns the number of fired timers. It panics if the timers don't run out,
+// e.g. because a ticker is running. Timers scheduled by other goroutines
+// after they were woken up are only seen if they are scheduled in time, see
+// WaitForQuiescence.
+func (s *Simulated) RunUntilIdle() int {
+	fired := 0
+	for {
+		s.mu.Lock()
+		if len(s.scheduled) == 0 {
+			s.mu.Unlock()
+			return fired
+		}
+		at := s.scheduled[0].at
+		s.mu.Unlock()
+
+		if fired += s.runUntil(at); fired > maxIdleEvents {
+			panic("mclock: RunUntilIdle didn't run out of timers")
+		}
+	}
+}
+
+// runUntil executes the timers due up to end one by one and moves the clock to
+// end. It returns the number of fired timers.
+func (s *Simulated) runUntil(end AbsTime) int {
+	fired := 0
+	for {
+		s.mu.Lock()
+		s.init()
+		if len(s.scheduled) == 0 || s.scheduled[0].at > end {
+			if end > s.now {
+				s.now = end
+			}
+			s.mu.Unlock()
+			return fired
+		}
+		ev := heap.Pop(&s.scheduled).(*simTimer)
+		if ev.at > s.now {
+			s.now = ev.at
+		}
+		s.version++
+		event := SimEvent{Kind: SimFired, Timer: ev.id, Now: s.now, At: ev.at}
+		trace := s.trace
+		s.mu.Unlock()
+
+		if trace != nil {
+			trace(event)
+		}
+		ev.do()
+		fired++
+	}
+}
+
+// WaitForQuiescence waits until the goroutines using the clock have settled,
+// i.e. no timer was scheduled, stopped or fired and the number of goroutines
+// didn't change for a few consecutive polls. Unlike the clock, maxWait is
+// real time. It returns false if the goroutines didn't settle in time.
+//
+// This is a heuristic: a goroutine busy without touching the clock or
+// spawning goroutines is taken to be settled.
+func (s *Simulated) WaitForQuiescence(maxWait time.Duration) bool {
+	const stablePolls = 3
+
+	deadline := time.Now().Add(maxWait)
+	version, goroutines := s.getVersion(), runtime.NumGoroutine()
+	for stable := 0; stable < stablePolls; {
+		if time.Now().After(deadline) {
+			return false
+		}
+		runtime.Gosched()
+		time.Sleep(time.Millisecond)
+
+		v, g := s.getVersion(), runtime.NumGoroutine()
+		if v == version && g == goroutines {
+			stable++
+		} else {
+			stable = 0
+			version, goroutines = v, g
+		}
+	}
+	return true
+}
+
+// getVersion returns the current version of the schedule.
+func (s *Simulated) getVersion() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.version
+}
+
+// emit passes an event to the trace hook, if there is one. It must be called
+// without holding s.mu.
+func (s *Simulated) emit(kind SimEventKind, ev *simTimer, at AbsTime) {
+	s.mu.RLock()
+	trace, now := s.trace, s.now
+	s.mu.RUnlock()
+
+	if trace != nil {
+		trace(SimEvent{Kind: kind, Timer: ev.id, Now: now, At: at})
 	}
 }
 
@@ -101,6 +248,28 @@ func (s *Simulated) Now() AbsTime {
 	return s.now
 }
 
+// SetEpoch sets the wall-clock time corresponding to virtual time zero. The
+// default epoch is the Unix epoch in UTC.
+func (s *Simulated) SetEpoch(epoch time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.epoch = epoch
+}
+
+// Time returns the current virtual wall-clock time, i.e. the epoch advanced by
+// the virtual time.
+func (s *Simulated) Time() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	epoch := s.epoch
+	if epoch.IsZero() {
+		epoch = time.Unix(0, 0).UTC()
+	}
+	return epoch.Add(time.Duration(s.now))
+}
+
 // Sleep blocks until the clock has advanced by d.
 func (s *Simulated) Sleep(d time.Duration) {
 	<-s.After(d)
@@ -109,12 +278,14 @@ func (s *Simulated) Sleep(d time.Duration) {
 // NewTimer creates a timer which fires when the clock has advanced by d.
 func (s *Simulated) NewTimer(d time.Duration) ChanTimer {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	ch := make(chan AbsTime, 1)
 	var timer *simTimer
 	timer = s.schedule(d, func() { ch <- timer.at })
 	timer.ch = ch
+	at := timer.at
+	s.mu.Unlock()
+
+	s.emit(SimScheduled, timer, at)
 	return timer
 }
 
@@ -128,31 +299,46 @@ func (s *Simulated) After(d time.Duration) <-chan AbsTime {
 // clock, fn runs on the goroutine that calls Run.
 func (s *Simulated) AfterFunc(d time.Duration, fn func()) Timer {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	timer := s.schedule(d, fn)
+	at := timer.at
+	s.mu.Unlock()
+
+	s.emit(SimScheduled, timer, at)
+	return timer
+}
 
-	return s.schedule(d, fn)
+// NewTicker returns a ticker which ticks every time the clock has advanced by
+// d. Like AfterFunc callbacks, ticks are sent by the goroutine that calls Run.
+func (s *Simulated) NewTicker(d time.Duration) Ticker {
+	return newTicker(s, d)
 }
 
 func (s *Simulated) schedule(d time.Duration, fn func()) *simTimer {
 	s.init()
 
 	at := s.now + AbsTime(d)
-	ev := &simTimer{do: fn, at: at, s: s}
+	s.lastID++
+	ev := &simTimer{do: fn, at: at, id: s.lastID, s: s}
 	heap.Push(&s.scheduled, ev)
+	s.version++
 	s.cond.Broadcast()
 	return ev
 }
 
 func (ev *simTimer) Stop() bool {
 	ev.s.mu.Lock()
-	defer ev.s.mu.Unlock()
-
 	if ev.index < 0 {
+		ev.s.mu.Unlock()
 		return false
 	}
 	heap.Remove(&ev.s.scheduled, ev.index)
+	ev.s.version++
 	ev.s.cond.Broadcast()
 	ev.index = -1
+	at := ev.at
+	ev.s.mu.Unlock()
+
+	ev.s.emit(SimStopped, ev, at)
 	return true
 }
 
@@ -162,14 +348,18 @@ func (ev *simTimer) Reset(d time.Duration) {
 	}
 
 	ev.s.mu.Lock()
-	defer ev.s.mu.Unlock()
 	ev.at = ev.s.now.Add(d)
 	if ev.index < 0 {
 		heap.Push(&ev.s.scheduled, ev) // already expired
 	} else {
 		heap.Fix(&ev.s.scheduled, ev.index) // hasn't fired yet, reschedule
 	}
+	ev.s.version++
 	ev.s.cond.Broadcast()
+	at := ev.at
+	ev.s.mu.Unlock()
+
+	ev.s.emit(SimScheduled, ev, at)
 }
 
 func (ev *simTimer) C() <-chan AbsTime {
@@ -185,8 +375,11 @@ func (h *simTimerHeap) Len() int {
 	return len(*h)
 }
 
+// Less orders timers by time, and timers with the same time by creation, so
+// they always fire in the same order.
 func (h *simTimerHeap) Less(i, j int) bool {
-	return (*h)[i].at < (*h)[j].at
+	a, b := (*h)[i], (*h)[j]
+	return a.at < b.at || (a.at == b.at && a.id < b.id)
 }
 
 func (h *simTimerHeap) Swap(i, j int) {
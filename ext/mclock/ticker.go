@@ -0,0 +1,120 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kmclock
+
+import (
+	"sync"
+	"time"
+)
+
+// clockTicker implements Ticker on top of the AfterFunc of any clock. Ticks
+// are scheduled on multiples of the period since the start, so they don't
+// drift, and ticks a slow receiver missed are dropped like with time.Ticker.
+type clockTicker struct {
+	clock  Clock
+	ch     chan AbsTime
+	mu     sync.Mutex
+	period time.Duration
+	next   AbsTime // time of the next tick
+	timer  Timer   // nil once stopped
+	gen    uint64  // incremented on Reset and Stop to discard stale timers
+}
+
+// newTicker starts a ticker with period d on clock.
+func newTicker(clock Clock, d time.Duration) *clockTicker {
+	if d <= 0 {
+		panic("mclock: non-positive interval for NewTicker")
+	}
+	t := &clockTicker{clock: clock, ch: make(chan AbsTime, 1)}
+	t.start(d)
+	return t
+}
+
+// start schedules the first tick after d. It must be called with t.mu held or
+// before t is shared.
+func (t *clockTicker) start(d time.Duration) {
+	t.period = d
+	t.next = t.clock.Now().Add(d)
+	t.arm()
+}
+
+// arm sets the timer for the next tick. It must be called with t.mu held or
+// before t is shared.
+func (t *clockTicker) arm() {
+	gen := t.gen
+	d := t.next.Sub(t.clock.Now())
+	if d < 0 {
+		d = 0
+	}
+	t.timer = t.clock.AfterFunc(d, func() { t.tick(gen) })
+}
+
+// tick delivers a tick and schedules the next one.
+func (t *clockTicker) tick(gen uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if gen != t.gen || t.timer == nil {
+		return
+	}
+	now := t.clock.Now()
+	select {
+	case t.ch <- now:
+	default:
+	}
+	t.next = t.next.Add(t.period)
+	if t.next <= now {
+		// Skip the ticks which are already overdue.
+		behind := now.Sub(t.next)
+		t.next = t.next.Add((behind/t.period + 1) * t.period)
+	}
+	t.arm()
+}
+
+// C returns the channel which receives the ticks.
+func (t *clockTicker) C() <-chan AbsTime {
+	return t.ch
+}
+
+// Reset stops the ticker and restarts it with period d. The next tick arrives
+// after d has elapsed.
+func (t *clockTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("mclock: non-positive interval for Ticker.Reset")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stop()
+	t.start(d)
+}
+
+// Stop turns off the ticker. No more ticks are sent after it returns, but the
+// channel isn't closed.
+func (t *clockTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stop()
+}
+
+// stop cancels the pending timer. It must be called with t.mu held.
+func (t *clockTicker) stop() {
+	t.gen++
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
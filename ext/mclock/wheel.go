@@ -0,0 +1,294 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kmclock
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// TimingWheel implements Clock with a hierarchical timing wheel on top of
+// another clock. Scheduling and stopping timers is O(1) and, however many
+// timers are pending, only a single timer of the underlying clock is used to
+// drive the wheel.
+//
+// Timers fire on the first tick at or after their due time, so the tick
+// duration is the resolution of the wheel. Timer callbacks run on the
+// goroutine driving the wheel and should not block. Using Simulated as the
+// underlying clock makes the wheel fully deterministic.
+type TimingWheel struct {
+	clock     Clock
+	tick      time.Duration
+	wheelSize int64
+
+	mu      sync.Mutex
+	origin  AbsTime        // start of tick zero
+	current int64          // number of ticks processed so far
+	levels  [][]*list.List // level l has buckets spanning wheelSize^l ticks
+	count   int            // number of pending timers
+	driver  Timer          // next tick of the underlying clock, nil while idle
+}
+
+// wheelTimer implements ChanTimer on a timing wheel.
+type wheelTimer struct {
+	w      *TimingWheel
+	expiry int64 // tick on which the timer fires
+	do     func()
+	ch     chan AbsTime  // nil for timers created by AfterFunc
+	bucket *list.List    // bucket holding the timer, nil once fired or stopped
+	elem   *list.Element // position in bucket
+}
+
+// NewTimingWheel creates a timing wheel driven by clock which advances in steps
+// of tick and has wheelSize buckets per level. Further levels, each covering
+// wheelSize times the span of the previous one, are added as far-off timers
+// need them.
+func NewTimingWheel(clock Clock, tick time.Duration, wheelSize int) *TimingWheel {
+	if tick <= 0 {
+		tick = time.Millisecond
+	}
+	if wheelSize < 2 {
+		wheelSize = 2
+	}
+	w := &TimingWheel{
+		clock:     clock,
+		tick:      tick,
+		wheelSize: int64(wheelSize),
+		origin:    clock.Now(),
+	}
+	w.addLevel()
+	return w
+}
+
+// Now returns the current time of the underlying clock.
+func (w *TimingWheel) Now() AbsTime {
+	return w.clock.Now()
+}
+
+// Sleep blocks until the wheel fired a timer of duration d.
+func (w *TimingWheel) Sleep(d time.Duration) {
+	<-w.After(d)
+}
+
+// NewTimer creates a timer which can be rescheduled.
+func (w *TimingWheel) NewTimer(d time.Duration) ChanTimer {
+	ch := make(chan AbsTime, 1)
+	t := &wheelTimer{w: w, ch: ch}
+	t.do = func() {
+		// Non-blocking like the System timer, in case Reset is misused.
+		select {
+		case ch <- w.clock.Now():
+		default:
+		}
+	}
+	w.mu.Lock()
+	w.schedule(t, d)
+	w.mu.Unlock()
+	return t
+}
+
+// After returns a channel which receives the current time after d has elapsed.
+func (w *TimingWheel) After(d time.Duration) <-chan AbsTime {
+	return w.NewTimer(d).C()
+}
+
+// AfterFunc runs f after d has elapsed. Unlike with the system clock, f runs
+// on the goroutine driving the wheel.
+func (w *TimingWheel) AfterFunc(d time.Duration, f func()) Timer {
+	t := &wheelTimer{w: w, do: f}
+	w.mu.Lock()
+	w.schedule(t, d)
+	w.mu.Unlock()
+	return t
+}
+
+// NewTicker returns a ticker with period d whose ticks are driven by the wheel.
+func (w *TimingWheel) NewTicker(d time.Duration) Ticker {
+	return newTicker(w, d)
+}
+
+// Time returns the current wall-clock time of the underlying clock.
+func (w *TimingWheel) Time() time.Time {
+	return w.clock.Time()
+}
+
+// Pending returns the number of timers that haven't fired.
+func (w *TimingWheel) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.count
+}
+
+// schedule computes the expiry tick of t and inserts it. It must be called
+// with w.mu held.
+func (w *TimingWheel) schedule(t *wheelTimer, d time.Duration) {
+	now := w.clock.Now()
+	if w.count == 0 {
+		// Nothing can fire while idle, so skip the ticks that went by.
+		w.current = w.ticks(now)
+	}
+	due := now.Add(d).Sub(w.origin)
+	t.expiry = int64(due / w.tick)
+	if due%w.tick != 0 {
+		t.expiry++
+	}
+	if t.expiry <= w.current {
+		t.expiry = w.current + 1
+	}
+	w.insert(t)
+	w.count++
+
+	if w.driver == nil {
+		w.drive(now)
+	}
+}
+
+// insert places t into the bucket covering its expiry. The level is the lowest
+// one whose span reaches the expiry. It must be called with w.mu held.
+func (w *TimingWheel) insert(t *wheelTimer) {
+	delta := t.expiry - w.current
+	level, span := 0, int64(1)
+	for delta >= span*w.wheelSize && span <= math.MaxInt64/(w.wheelSize*w.wheelSize) {
+		span *= w.wheelSize
+		level++
+	}
+	for level >= len(w.levels) {
+		w.addLevel()
+	}
+	t.bucket = w.levels[level][(t.expiry/span)%w.wheelSize]
+	t.elem = t.bucket.PushBack(t)
+}
+
+// addLevel adds an outer level to the wheel.
+func (w *TimingWheel) addLevel() {
+	buckets := make([]*list.List, w.wheelSize)
+	for i := range buckets {
+		buckets[i] = list.New()
+	}
+	w.levels = append(w.levels, buckets)
+}
+
+// remove takes t out of its bucket. It must be called with w.mu held.
+func (w *TimingWheel) remove(t *wheelTimer) {
+	t.bucket.Remove(t.elem)
+	t.bucket, t.elem = nil, nil
+	w.count--
+}
+
+// ticks returns the number of whole ticks between the origin and now.
+func (w *TimingWheel) ticks(now AbsTime) int64 {
+	return int64(now.Sub(w.origin) / w.tick)
+}
+
+// drive schedules the underlying clock to wake the wheel on the next tick. It
+// must be called with w.mu held.
+func (w *TimingWheel) drive(now AbsTime) {
+	next := w.origin.Add(time.Duration(w.current+1) * w.tick)
+	d := next.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	w.driver = w.clock.AfterFunc(d, w.run)
+}
+
+// run advances the wheel to the current time of the underlying clock and fires
+// the expired timers.
+func (w *TimingWheel) run() {
+	w.mu.Lock()
+	w.driver = nil
+	now := w.clock.Now()
+	fired := w.advance(w.ticks(now))
+	if w.count > 0 {
+		w.drive(now)
+	}
+	w.mu.Unlock()
+
+	for _, fn := range fired {
+		fn()
+	}
+}
+
+// advance processes the ticks up to target, cascading timers from the outer
+// levels into the inner ones as their bucket comes up, and returns the
+// callbacks of the expired timers. It must be called with w.mu held.
+func (w *TimingWheel) advance(target int64) []func() {
+	var fired []func()
+	for w.current < target {
+		w.current++
+		span := w.wheelSize
+		for l := 1; l < len(w.levels) && w.current%span == 0; l++ {
+			bucket := w.levels[l][(w.current/span)%w.wheelSize]
+			for e := bucket.Front(); e != nil; {
+				next := e.Next()
+				t := e.Value.(*wheelTimer)
+				bucket.Remove(e)
+				w.insert(t)
+				e = next
+			}
+			span *= w.wheelSize
+		}
+		bucket := w.levels[0][w.current%w.wheelSize]
+		for e := bucket.Front(); e != nil; e = bucket.Front() {
+			t := e.Value.(*wheelTimer)
+			w.remove(t)
+			fired = append(fired, t.do)
+		}
+	}
+	return fired
+}
+
+// Stop cancels the timer. It returns false if the timer has already expired or
+// been stopped.
+func (t *wheelTimer) Stop() bool {
+	w := t.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t.bucket == nil {
+		return false
+	}
+	w.remove(t)
+	if w.count == 0 && w.driver != nil {
+		w.driver.Stop()
+		w.driver = nil
+	}
+	return true
+}
+
+// Reset reschedules the timer with a new timeout.
+func (t *wheelTimer) Reset(d time.Duration) {
+	if t.ch == nil {
+		panic("mclock: Reset() on timer created by AfterFunc")
+	}
+	w := t.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t.bucket != nil {
+		w.remove(t)
+	}
+	w.schedule(t, d)
+}
+
+// C returns the channel which receives a value when the timer expires.
+func (t *wheelTimer) C() <-chan AbsTime {
+	if t.ch == nil {
+		panic("mclock: C() on timer created by AfterFunc")
+	}
+	return t.ch
+}
@@ -18,7 +18,7 @@ import (
 	"container/heap"
 	"time"
 
-	kmclock "github.com/miczone/fdlib/ext/mclock"
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
 )
 
 // LazyQueue is a priority queue data structure where priorities can change over
@@ -34,12 +34,12 @@ type LazyQueue struct {
 	// Items are stored in one of two internal queues ordered by estimated max
 	// priority until the next and the next-after-next refresh. Update and Refresh
 	// always places items in queue[1].
-	queue                      [2]*sstack
-	popQueue                   *sstack
+	queue                      [2]*sstack[interface{}]
+	popQueue                   *sstack[interface{}]
 	period                     time.Duration
 	maxUntil                   kmclock.AbsTime
 	indexOffset                int
-	setIndex                   SetIndexCallback
+	setIndex                   SetIndexCallback[interface{}]
 	priority                   PriorityCallback
 	maxPriority                MaxPriorityCallback
 	lastRefresh1, lastRefresh2 kmclock.AbsTime
@@ -51,9 +51,9 @@ type (
 )
 
 // NewLazyQueue creates a new lazy queue
-func NewLazyQueue(setIndex SetIndexCallback, priority PriorityCallback, maxPriority MaxPriorityCallback, clock mclock.Clock, refreshPeriod time.Duration) *LazyQueue {
+func NewLazyQueue(setIndex SetIndexCallback[interface{}], priority PriorityCallback, maxPriority MaxPriorityCallback, clock kmclock.Clock, refreshPeriod time.Duration) *LazyQueue {
 	q := &LazyQueue{
-		popQueue:     newSstack(nil, false),
+		popQueue:     newSstack(nil, maxFirst[interface{}]),
 		setIndex:     setIndex,
 		priority:     priority,
 		maxPriority:  maxPriority,
@@ -69,8 +69,8 @@ func NewLazyQueue(setIndex SetIndexCallback, priority PriorityCallback, maxPrior
 
 // Reset clears the contents of the queue
 func (q *LazyQueue) Reset() {
-	q.queue[0] = newSstack(q.setIndex0, false)
-	q.queue[1] = newSstack(q.setIndex1, false)
+	q.queue[0] = newSstack(q.setIndex0, maxFirst[interface{}])
+	q.queue[1] = newSstack(q.setIndex1, maxFirst[interface{}])
 }
 
 // Refresh performs queue re-evaluation if necessary
@@ -87,7 +87,7 @@ func (q *LazyQueue) Refresh() {
 func (q *LazyQueue) refresh(now kmclock.AbsTime) {
 	q.maxUntil = now + kmclock.AbsTime(q.period)
 	for q.queue[0].Len() != 0 {
-		q.Push(heap.Pop(q.queue[0]).(*item).value)
+		q.Push(heap.Pop(q.queue[0]).(*item[interface{}]).value)
 	}
 	q.queue[0], q.queue[1] = q.queue[1], q.queue[0]
 	q.indexOffset = 1 - q.indexOffset
@@ -96,7 +96,7 @@ func (q *LazyQueue) refresh(now kmclock.AbsTime) {
 
 // Push adds an item to the queue
 func (q *LazyQueue) Push(data interface{}) {
-	heap.Push(q.queue[1], &item{data, q.maxPriority(data, q.maxUntil)})
+	heap.Push(q.queue[1], &item[interface{}]{data, q.maxPriority(data, q.maxUntil)})
 }
 
 // Update updates the upper priority estimate for the item with the given queue index
@@ -139,14 +139,14 @@ func (q *LazyQueue) peekIndex() int {
 func (q *LazyQueue) MultiPop(callback func(data interface{}, priority int64) bool) {
 	nextIndex := q.peekIndex()
 	for nextIndex != -1 {
-		data := heap.Pop(q.queue[nextIndex]).(*item).value
-		heap.Push(q.popQueue, &item{data, q.priority(data)})
+		data := heap.Pop(q.queue[nextIndex]).(*item[interface{}]).value
+		heap.Push(q.popQueue, &item[interface{}]{data, q.priority(data)})
 		nextIndex = q.peekIndex()
 		for q.popQueue.Len() != 0 && (nextIndex == -1 || q.queue[nextIndex].blocks[0][0].priority < q.popQueue.blocks[0][0].priority) {
-			i := heap.Pop(q.popQueue).(*item)
+			i := heap.Pop(q.popQueue).(*item[interface{}])
 			if !callback(i.value, i.priority) {
 				for q.popQueue.Len() != 0 {
-					q.Push(heap.Pop(q.popQueue).(*item).value)
+					q.Push(heap.Pop(q.popQueue).(*item[interface{}]).value)
 				}
 				return
 			}
@@ -166,7 +166,7 @@ func (q *LazyQueue) Remove(index int) interface{} {
 	if index < 0 {
 		return nil
 	}
-	return heap.Remove(q.queue[index&1^q.indexOffset], index>>1).(*item).value
+	return heap.Remove(q.queue[index&1^q.indexOffset], index>>1).(*item[interface{}]).value
 }
 
 // Empty checks whether the priority queue is empty.
@@ -11,7 +11,9 @@
 // Package prque implements a priority queue data structure supporting arbitrary
 // value types and int64 priorities.
 //
-// If you would like to use a min-priority queue, simply negate the priorities.
+// By default the item with the highest priority is popped first, NewMin creates
+// a min-priority queue instead and NewWithComparator orders the values by a
+// custom function.
 //
 // Internally the queue is based on the standard heap package working on a
 // sortable version of the block based stack.
@@ -22,62 +24,103 @@ import (
 )
 
 // Priority queue data structure.
-type Prque struct {
-	cont *sstack
+type Prque[T any] struct {
+	cont *sstack[T]
 }
 
-// New creates a new priority queue.
-func New(setIndex SetIndexCallback) *Prque {
-	return &Prque{newSstack(setIndex, false)}
+// New creates a new priority queue popping the highest priority first.
+func New[T any](setIndex SetIndexCallback[T]) *Prque[T] {
+	return &Prque[T]{newSstack(setIndex, maxFirst[T])}
+}
+
+// NewMin creates a new priority queue popping the lowest priority first.
+func NewMin[T any](setIndex SetIndexCallback[T]) *Prque[T] {
+	return &Prque[T]{newSstack(setIndex, minFirst[T])}
 }
 
 // NewWrapAround creates a new priority queue with wrap-around priority handling.
-func NewWrapAround(setIndex SetIndexCallback) *Prque {
-	return &Prque{newSstack(setIndex, true)}
+func NewWrapAround[T any](setIndex SetIndexCallback[T]) *Prque[T] {
+	return &Prque[T]{newSstack(setIndex, wrapAround[T])}
+}
+
+// NewWithComparator creates a new priority queue ordered by before, which
+// reports whether a should be popped before b. Priorities are only carried
+// along with the values and don't affect the order.
+func NewWithComparator[T any](setIndex SetIndexCallback[T], before func(a, b T) bool) *Prque[T] {
+	return &Prque[T]{newSstack(setIndex, func(a, b *item[T]) bool {
+		return before(a.value, b.value)
+	})}
 }
 
 // Pushes a value with a given priority into the queue, expanding if necessary.
-func (p *Prque) Push(data interface{}, priority int64) {
-	heap.Push(p.cont, &item{data, priority})
+func (p *Prque[T]) Push(data T, priority int64) {
+	heap.Push(p.cont, &item[T]{data, priority})
 }
 
-// Peek returns the value with the greates priority but does not pop it off.
-func (p *Prque) Peek() (interface{}, int64) {
+// Peek returns the value with the greatest priority but does not pop it off.
+// The last return value is false if the queue is empty.
+func (p *Prque[T]) Peek() (T, int64, bool) {
+	if p.cont.Len() == 0 {
+		var zero T
+		return zero, 0, false
+	}
 	item := p.cont.blocks[0][0]
-	return item.value, item.priority
+	return item.value, item.priority, true
 }
 
-// Pops the value with the greates priority off the stack and returns it.
+// Pops the value with the greatest priority off the stack and returns it.
+// The last return value is false if the queue is empty.
 // Currently no shrinking is done.
-func (p *Prque) Pop() (interface{}, int64) {
-	item := heap.Pop(p.cont).(*item)
-	return item.value, item.priority
+func (p *Prque[T]) Pop() (T, int64, bool) {
+	if p.cont.Len() == 0 {
+		var zero T
+		return zero, 0, false
+	}
+	item := heap.Pop(p.cont).(*item[T])
+	return item.value, item.priority, true
 }
 
 // Pops only the item from the queue, dropping the associated priority value.
-func (p *Prque) PopItem() interface{} {
-	return heap.Pop(p.cont).(*item).value
+func (p *Prque[T]) PopItem() (T, bool) {
+	value, _, ok := p.Pop()
+	return value, ok
+}
+
+// Remove removes the element with the given index. The second return value is
+// false if there is no such element.
+func (p *Prque[T]) Remove(i int) (T, bool) {
+	if i < 0 || i >= p.cont.Len() {
+		var zero T
+		return zero, false
+	}
+	return heap.Remove(p.cont, i).(*item[T]).value, true
 }
 
-// Remove removes the element with the given index.
-func (p *Prque) Remove(i int) interface{} {
-	if i < 0 {
-		return nil
+// Update changes the priority of the element with the given index and moves it
+// to its new position, which makes it a decrease-key or increase-key operation.
+// With a comparator the priority doesn't matter, so Update can be used to
+// restore the order after the value itself changed. It returns false if there
+// is no element at the index.
+func (p *Prque[T]) Update(i int, priority int64) bool {
+	if i < 0 || i >= p.cont.Len() {
+		return false
 	}
-	return heap.Remove(p.cont, i)
+	p.cont.blocks[i/blockSize][i%blockSize].priority = priority
+	heap.Fix(p.cont, i)
+	return true
 }
 
 // Checks whether the priority queue is empty.
-func (p *Prque) Empty() bool {
+func (p *Prque[T]) Empty() bool {
 	return p.cont.Len() == 0
 }
 
 // Returns the number of element in the priority queue.
-func (p *Prque) Size() int {
+func (p *Prque[T]) Size() int {
 	return p.cont.Len()
 }
 
 // Clears the contents of the priority queue.
-func (p *Prque) Reset() {
-	*p = *New(p.cont.setIndex)
+func (p *Prque[T]) Reset() {
+	p.cont.Reset()
 }
@@ -17,46 +17,51 @@ const blockSize = 4096
 //
 // Note: priorities can "wrap around" the int64 range, a comes before b if (a.priority - b.priority) > 0.
 // The difference between the lowest and highest priorities in the queue at any point should be less than 2^63.
-type item struct {
-	value    interface{}
+type item[T any] struct {
+	value    T
 	priority int64
 }
 
 // SetIndexCallback is called when the element is moved to a new index.
 // Providing SetIndexCallback is optional, it is needed only if the application needs
-// to delete elements other than the top one.
-type SetIndexCallback func(data interface{}, index int)
+// to delete or update elements other than the top one.
+type SetIndexCallback[T any] func(data T, index int)
+
+// Ordering functions of the stack, reporting whether a is popped before b.
+func maxFirst[T any](a, b *item[T]) bool   { return a.priority > b.priority }
+func minFirst[T any](a, b *item[T]) bool   { return a.priority < b.priority }
+func wrapAround[T any](a, b *item[T]) bool { return a.priority-b.priority > 0 }
 
 // Internal sortable stack data structure. Implements the Push and Pop ops for
 // the stack (heap) functionality and the Len, Less and Swap methods for the
 // sortability requirements of the heaps.
-type sstack struct {
-	setIndex   SetIndexCallback
-	size       int
-	capacity   int
-	offset     int
-	wrapAround bool
+type sstack[T any] struct {
+	setIndex SetIndexCallback[T]
+	size     int
+	capacity int
+	offset   int
+	before   func(a, b *item[T]) bool
 
-	blocks [][]*item
-	active []*item
+	blocks [][]*item[T]
+	active []*item[T]
 }
 
 // Creates a new, empty stack.
-func newSstack(setIndex SetIndexCallback, wrapAround bool) *sstack {
-	result := new(sstack)
+func newSstack[T any](setIndex SetIndexCallback[T], before func(a, b *item[T]) bool) *sstack[T] {
+	result := new(sstack[T])
 	result.setIndex = setIndex
-	result.active = make([]*item, blockSize)
-	result.blocks = [][]*item{result.active}
+	result.active = make([]*item[T], blockSize)
+	result.blocks = [][]*item[T]{result.active}
 	result.capacity = blockSize
-	result.wrapAround = wrapAround
+	result.before = before
 	return result
 }
 
 // Pushes a value onto the stack, expanding it if necessary. Required by
 // heap.Interface.
-func (s *sstack) Push(data interface{}) {
+func (s *sstack[T]) Push(data interface{}) {
 	if s.size == s.capacity {
-		s.active = make([]*item, blockSize)
+		s.active = make([]*item[T], blockSize)
 		s.blocks = append(s.blocks, s.active)
 		s.capacity += blockSize
 		s.offset = 0
@@ -65,16 +70,16 @@ func (s *sstack) Push(data interface{}) {
 		s.offset = 0
 	}
 	if s.setIndex != nil {
-		s.setIndex(data.(*item).value, s.size)
+		s.setIndex(data.(*item[T]).value, s.size)
 	}
-	s.active[s.offset] = data.(*item)
+	s.active[s.offset] = data.(*item[T])
 	s.offset++
 	s.size++
 }
 
 // Pops a value off the stack and returns it. Currently no shrinking is done.
 // Required by heap.Interface.
-func (s *sstack) Pop() (res interface{}) {
+func (s *sstack[T]) Pop() (res interface{}) {
 	s.size--
 	s.offset--
 	if s.offset < 0 {
@@ -83,28 +88,24 @@ func (s *sstack) Pop() (res interface{}) {
 	}
 	res, s.active[s.offset] = s.active[s.offset], nil
 	if s.setIndex != nil {
-		s.setIndex(res.(*item).value, -1)
+		s.setIndex(res.(*item[T]).value, -1)
 	}
 	return
 }
 
 // Returns the length of the stack. Required by sort.Interface.
-func (s *sstack) Len() int {
+func (s *sstack[T]) Len() int {
 	return s.size
 }
 
-// Compares the priority of two elements of the stack (higher is first).
+// Compares two elements of the stack using its ordering function.
 // Required by sort.Interface.
-func (s *sstack) Less(i, j int) bool {
-	a, b := s.blocks[i/blockSize][i%blockSize].priority, s.blocks[j/blockSize][j%blockSize].priority
-	if s.wrapAround {
-		return a-b > 0
-	}
-	return a > b
+func (s *sstack[T]) Less(i, j int) bool {
+	return s.before(s.blocks[i/blockSize][i%blockSize], s.blocks[j/blockSize][j%blockSize])
 }
 
 // Swaps two elements in the stack. Required by sort.Interface.
-func (s *sstack) Swap(i, j int) {
+func (s *sstack[T]) Swap(i, j int) {
 	ib, io, jb, jo := i/blockSize, i%blockSize, j/blockSize, j%blockSize
 	a, b := s.blocks[jb][jo], s.blocks[ib][io]
 	if s.setIndex != nil {
@@ -115,6 +116,6 @@ func (s *sstack) Swap(i, j int) {
 }
 
 // Resets the stack, effectively clearing its contents.
-func (s *sstack) Reset() {
-	*s = *newSstack(s.setIndex, false)
+func (s *sstack[T]) Reset() {
+	*s = *newSstack(s.setIndex, s.before)
 }
@@ -0,0 +1,266 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prque
+
+import (
+	"context"
+	"sync"
+
+	"github.com/wokaio/fdlib/internal/syncutil"
+)
+
+// SyncPrque is a Prque which is safe for concurrent use. Pushes wake up callers
+// blocked in PopWait.
+//
+// The SetIndexCallback of the wrapped queue is invoked with the lock held, so it
+// must not call back into the SyncPrque.
+type SyncPrque[T any] struct {
+	lock  sync.Mutex
+	cond  *sync.Cond
+	queue *Prque[T]
+}
+
+// NewSyncPrque wraps queue for concurrent use. The queue must not be used
+// directly afterwards.
+func NewSyncPrque[T any](queue *Prque[T]) *SyncPrque[T] {
+	p := &SyncPrque[T]{queue: queue}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+// Push adds a value with a given priority and wakes up one waiting popper.
+func (p *SyncPrque[T]) Push(data T, priority int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.queue.Push(data, priority)
+	p.cond.Signal()
+}
+
+// Peek returns the value with the greatest priority but does not pop it off.
+func (p *SyncPrque[T]) Peek() (T, int64, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.queue.Peek()
+}
+
+// Pop pops the value with the greatest priority, if there is one.
+func (p *SyncPrque[T]) Pop() (T, int64, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.queue.Pop()
+}
+
+// PopItem pops the value with the greatest priority, dropping the priority.
+func (p *SyncPrque[T]) PopItem() (T, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.queue.PopItem()
+}
+
+// PopWait pops the value with the greatest priority, blocking while the queue
+// is empty. It returns the context error if ctx is done first.
+func (p *SyncPrque[T]) PopWait(ctx context.Context) (T, int64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := syncutil.WaitCond(ctx, p.cond, func() bool { return !p.queue.Empty() }); err != nil {
+		var zero T
+		return zero, 0, err
+	}
+	data, priority, _ := p.queue.Pop()
+	return data, priority, nil
+}
+
+// MultiPop pops values in priority order and passes them to the callback until
+// it returns false or the queue is empty. The lock is released while the
+// callback runs, so it may push values back into the queue.
+func (p *SyncPrque[T]) MultiPop(callback func(data T, priority int64) bool) {
+	for {
+		data, priority, ok := p.Pop()
+		if !ok || !callback(data, priority) {
+			return
+		}
+	}
+}
+
+// Remove removes the element with the given index.
+func (p *SyncPrque[T]) Remove(i int) (T, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.queue.Remove(i)
+}
+
+// Update changes the priority of the element with the given index.
+func (p *SyncPrque[T]) Update(i int, priority int64) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.queue.Update(i, priority)
+}
+
+// Empty checks whether the priority queue is empty.
+func (p *SyncPrque[T]) Empty() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.queue.Empty()
+}
+
+// Size returns the number of elements in the priority queue.
+func (p *SyncPrque[T]) Size() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.queue.Size()
+}
+
+// Reset clears the contents of the priority queue.
+func (p *SyncPrque[T]) Reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.queue.Reset()
+}
+
+// SyncLazyQueue is a LazyQueue which is safe for concurrent use. Pushes wake up
+// callers blocked in PopWait.
+//
+// The callbacks given to NewLazyQueue are invoked with the lock held, so they
+// must not call back into the SyncLazyQueue. MultiPop callbacks are not
+// affected by this.
+type SyncLazyQueue struct {
+	lock  sync.Mutex
+	cond  *sync.Cond
+	queue *LazyQueue
+}
+
+// NewSyncLazyQueue wraps queue for concurrent use. The queue must not be used
+// directly afterwards.
+func NewSyncLazyQueue(queue *LazyQueue) *SyncLazyQueue {
+	q := &SyncLazyQueue{queue: queue}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// Push adds an item to the queue and wakes up one waiting popper.
+func (q *SyncLazyQueue) Push(data interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.queue.Push(data)
+	q.cond.Signal()
+}
+
+// Update updates the upper priority estimate for the item with the given queue index.
+func (q *SyncLazyQueue) Update(index int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.queue.Update(index)
+}
+
+// Refresh performs queue re-evaluation if necessary.
+func (q *SyncLazyQueue) Refresh() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.queue.Refresh()
+}
+
+// Pop removes and returns the item with the greatest actual priority.
+func (q *SyncLazyQueue) Pop() (interface{}, int64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.queue.Pop()
+}
+
+// PopItem pops the item from the queue only, dropping the associated priority value.
+func (q *SyncLazyQueue) PopItem() interface{} {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.queue.PopItem()
+}
+
+// PopWait removes the item with the greatest actual priority, blocking while
+// the queue is empty. It returns the context error if ctx is done first.
+func (q *SyncLazyQueue) PopWait(ctx context.Context) (interface{}, int64, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := syncutil.WaitCond(ctx, q.cond, func() bool { return !q.queue.Empty() }); err != nil {
+		return nil, 0, err
+	}
+	data, priority := q.queue.Pop()
+	return data, priority, nil
+}
+
+// MultiPop pops items in priority order and passes them to the callback until
+// it returns false or the queue is empty. Unlike LazyQueue.MultiPop, items are
+// popped one by one and the lock is released while the callback runs, so it
+// may push items back into the queue.
+func (q *SyncLazyQueue) MultiPop(callback func(data interface{}, priority int64) bool) {
+	for {
+		q.lock.Lock()
+		if q.queue.Empty() {
+			q.lock.Unlock()
+			return
+		}
+		data, priority := q.queue.Pop()
+		q.lock.Unlock()
+
+		if !callback(data, priority) {
+			return
+		}
+	}
+}
+
+// Remove removes the item with the given index.
+func (q *SyncLazyQueue) Remove(index int) interface{} {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.queue.Remove(index)
+}
+
+// Empty checks whether the priority queue is empty.
+func (q *SyncLazyQueue) Empty() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.queue.Empty()
+}
+
+// Size returns the number of items in the priority queue.
+func (q *SyncLazyQueue) Size() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.queue.Size()
+}
+
+// Reset clears the contents of the queue.
+func (q *SyncLazyQueue) Reset() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.queue.Reset()
+}
@@ -1,13 +1,15 @@
 module github.com/wokaio/fdlib
 
-go 1.16
+go 1.18
 
 require (
-	github.com/adrg/postcode v0.1.0 // indirect
-	github.com/aristanetworks/goarista v0.0.0-20210319202508-5b0c587084ea // indirect
-	github.com/linuxpham/go.uuid v1.2.6 // indirect
-	github.com/oschwald/geoip2-golang v1.5.0 // indirect
-	github.com/speps/go-hashids v2.0.0+incompatible // indirect
-	github.com/uber/h3-go/v3 v3.7.1 // indirect
+	github.com/adrg/postcode v0.1.0
+	github.com/aristanetworks/goarista v0.0.0-20210319202508-5b0c587084ea
+	github.com/linuxpham/go.uuid v1.2.6
+	github.com/oschwald/geoip2-golang v1.5.0
+	github.com/speps/go-hashids v2.0.0+incompatible
+	github.com/uber/h3-go/v3 v3.7.1
 	golang.org/x/sys v0.0.0-20210403161142-5e06dd20ab57 // indirect
 )
+
+require github.com/oschwald/maxminddb-golang v1.8.0 // indirect
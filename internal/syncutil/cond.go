@@ -0,0 +1,49 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package syncutil contains synchronization helpers shared by the queue
+// packages.
+package syncutil
+
+import (
+	"context"
+	"sync"
+)
+
+// WaitCond blocks on cond until ready returns true or ctx is done, in which
+// case it returns the context error. It must be called with cond.L held.
+func WaitCond(ctx context.Context, cond *sync.Cond, ready func() bool) error {
+	if done := ctx.Done(); done != nil && !ready() {
+		// sync.Cond can't select on a channel, so wake the waiters up when the
+		// context is done and let them re-check its error.
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				cond.L.Lock()
+				cond.Broadcast()
+				cond.L.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	for !ready() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cond.Wait()
+	}
+	return nil
+}
@@ -24,6 +24,8 @@ import (
 	"sync"
 	"time"
 	"unicode"
+
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
 )
 
 const (
@@ -51,6 +53,7 @@ type MetricStats struct {
 	counterMap   map[string]*CounterNumber
 	gaugeMap     map[string]*GaugeNumber
 	stateMap     map[string]*StateNumber
+	clock        kmclock.Clock
 
 	lock        sync.RWMutex
 	metricsLast *MetricsData
@@ -59,6 +62,12 @@ type MetricStats struct {
 
 // NewMetricStats returns a new, empty MetricStats
 func NewMetricStats(metrics interface{}, prefix string, interval int) (*MetricStats, error) {
+	return NewMetricStatsWithClock(metrics, prefix, interval, kmclock.System{})
+}
+
+// NewMetricStatsWithClock returns a new, empty MetricStats whose counter diffs
+// are updated on the given clock
+func NewMetricStatsWithClock(metrics interface{}, prefix string, interval int, clock kmclock.Clock) (*MetricStats, error) {
 	m := new(MetricStats)
 	if err := validateMetrics(metrics); err != nil {
 		return m, err
@@ -71,6 +80,7 @@ func NewMetricStats(metrics interface{}, prefix string, interval int) (*MetricSt
 	m.metricStruct = metrics
 	m.metricPrefix = prefix
 	m.interval = interval
+	m.clock = clock
 	m.initMetrics(metrics)
 
 	m.metricsLast = m.GetAll()
@@ -188,9 +198,9 @@ func (m *MetricStats) handleCounterDiff(interval int) {
 	for {
 		m.updateDiff()
 
-		seconds := time.Now().Second()
+		seconds := m.clock.Time().Second()
 		left := m.interval - seconds%m.interval
-		time.Sleep(time.Duration(left) * time.Second)
+		m.clock.Sleep(time.Duration(left) * time.Second)
 	}
 }
 
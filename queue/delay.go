@@ -0,0 +1,191 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
+	"github.com/wokaio/fdlib/ext/prque"
+)
+
+// DelayHandle identifies an item scheduled on a DelayQueue and can be used to
+// cancel it before it is taken.
+type DelayHandle struct {
+	item  interface{}
+	due   kmclock.AbsTime
+	index int // position in the priority queue, -1 once taken or cancelled
+}
+
+// Due returns the time at which the item becomes visible.
+func (h *DelayHandle) Due() kmclock.AbsTime {
+	return h.due
+}
+
+// DelayQueue holds items which only become visible once their due time has
+// passed on the queue's clock. Items are taken in due time order.
+type DelayQueue struct {
+	lock   sync.Mutex
+	clock  kmclock.Clock
+	queue  *prque.Prque[*DelayHandle]
+	wake   chan struct{} // nudges a blocked Take to re-check the head
+	quit   chan struct{}
+	closed bool
+}
+
+// NewDelayQueue returns a new, empty DelayQueue driven by clock.
+func NewDelayQueue(clock kmclock.Clock) *DelayQueue {
+	q := &DelayQueue{
+		clock: clock,
+		wake:  make(chan struct{}, 1),
+		quit:  make(chan struct{}),
+	}
+	q.queue = prque.NewMin(func(h *DelayHandle, index int) {
+		h.index = index
+	})
+	return q
+}
+
+// Put schedules item to become visible after delay.
+func (q *DelayQueue) Put(item interface{}, delay time.Duration) (*DelayHandle, error) {
+	return q.PutAt(item, q.clock.Now().Add(delay))
+}
+
+// PutAt schedules item to become visible at the given clock time.
+func (q *DelayQueue) PutAt(item interface{}, due kmclock.AbsTime) (*DelayHandle, error) {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return nil, ErrQueueClosed
+	}
+	h := &DelayHandle{item: item, due: due}
+	q.queue.Push(h, int64(due))
+	q.lock.Unlock()
+
+	q.notify()
+	return h, nil
+}
+
+// Cancel removes the item scheduled under h. It returns false if the item has
+// already been taken or cancelled.
+func (q *DelayQueue) Cancel(h *DelayHandle) bool {
+	q.lock.Lock()
+	if h.index < 0 {
+		q.lock.Unlock()
+		return false
+	}
+	q.queue.Remove(h.index)
+	q.lock.Unlock()
+
+	q.notify()
+	return true
+}
+
+// Take removes the item with the earliest due time, blocking until it is due.
+// It returns the context error if ctx is done first, or ErrQueueClosed once
+// the queue is closed.
+func (q *DelayQueue) Take(ctx context.Context) (interface{}, error) {
+	for {
+		item, wait, err := q.poll()
+		if err != nil || wait == 0 {
+			return item, err
+		}
+
+		var (
+			timer kmclock.ChanTimer
+			fire  <-chan kmclock.AbsTime
+		)
+		if wait > 0 {
+			timer = q.clock.NewTimer(wait)
+			fire = timer.C()
+		}
+		select {
+		case <-fire:
+		case <-q.wake:
+		case <-q.quit:
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			q.notify() // let another waiter pick up what this one was waiting for
+			return nil, ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// TryTake removes the item with the earliest due time if it is already due.
+func (q *DelayQueue) TryTake() (interface{}, bool) {
+	item, wait, err := q.poll()
+	if err != nil || wait != 0 {
+		return nil, false
+	}
+	return item, true
+}
+
+// Len returns the number of scheduled items, whether they are due or not.
+func (q *DelayQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.queue.Size()
+}
+
+// Close wakes up all blocked callers. Items still scheduled are dropped.
+func (q *DelayQueue) Close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.quit)
+	}
+}
+
+// poll pops the head item if it is due. Otherwise it returns how long until
+// the head is due, or -1 if the queue is empty.
+func (q *DelayQueue) poll() (interface{}, time.Duration, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return nil, 0, ErrQueueClosed
+	}
+	h, _, ok := q.queue.Peek()
+	if !ok {
+		return nil, -1, nil
+	}
+	if now := q.clock.Now(); h.due > now {
+		return nil, h.due.Sub(now), nil
+	}
+	q.queue.Pop()
+	if !q.queue.Empty() {
+		// Pushes only wake a single waiter, pass it on for the next item.
+		q.notify()
+	}
+	return h.item, 0, nil
+}
+
+// notify wakes up one blocked Take, if there is any.
+func (q *DelayQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
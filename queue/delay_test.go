@@ -0,0 +1,194 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
+)
+
+// taken is an item returned by Take, along with the clock time it was taken.
+type taken struct {
+	item interface{}
+	at   kmclock.AbsTime
+}
+
+// startTaker runs Take in a loop until ctx is done, reporting every item.
+func startTaker(ctx context.Context, q *DelayQueue, clock kmclock.Clock) <-chan taken {
+	ch := make(chan taken, 16)
+	go func() {
+		defer close(ch)
+		for {
+			item, err := q.Take(ctx)
+			if err != nil {
+				return
+			}
+			ch <- taken{item, clock.Now()}
+		}
+	}()
+	return ch
+}
+
+// expectTaken waits for the taker to report the given item at the given time.
+func expectTaken(t *testing.T, ch <-chan taken, item interface{}, at kmclock.AbsTime) {
+	t.Helper()
+
+	select {
+	case got := <-ch:
+		if got.item != item || got.at != at {
+			t.Fatalf("have %v at %v, want %v at %v", got.item, got.at, item, at)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%v not taken", item)
+	}
+}
+
+// expectNothing checks that the taker doesn't report any item.
+func expectNothing(t *testing.T, clock *kmclock.Simulated, ch <-chan taken) {
+	t.Helper()
+
+	clock.WaitForQuiescence(time.Second)
+	select {
+	case got := <-ch:
+		t.Fatalf("unexpected %v taken at %v", got.item, got.at)
+	default:
+	}
+}
+
+func TestDelayQueueOrder(t *testing.T) {
+	var clock kmclock.Simulated
+	q := NewDelayQueue(&clock)
+
+	q.Put("c", 3*time.Second)
+	q.Put("a", time.Second)
+	q.Put("b", 2*time.Second)
+	if _, ok := q.TryTake(); ok {
+		t.Fatal("took an item before it was due")
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		clock.Run(time.Second)
+		if item, ok := q.TryTake(); !ok || item != want {
+			t.Fatalf("at %v: have %v, %v, want %s", clock.Now(), item, ok, want)
+		}
+		if item, ok := q.TryTake(); ok {
+			t.Fatalf("at %v: took %v before it was due", clock.Now(), item)
+		}
+	}
+	if q.Len() != 0 {
+		t.Fatalf("length: have %d, want 0", q.Len())
+	}
+}
+
+func TestDelayQueueTake(t *testing.T) {
+	var clock kmclock.Simulated
+	q := NewDelayQueue(&clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q.Put("b", 5*time.Second)
+	q.Put("a", 2*time.Second)
+	ch := startTaker(ctx, q, &clock)
+	clock.WaitForTimers(1)
+
+	clock.Run(2*time.Second - 1)
+	expectNothing(t, &clock, ch)
+	clock.Run(1)
+	expectTaken(t, ch, "a", kmclock.AbsTime(2*time.Second))
+
+	clock.WaitForQuiescence(time.Second)
+	clock.Run(3 * time.Second)
+	expectTaken(t, ch, "b", kmclock.AbsTime(5*time.Second))
+
+	// Items due at the same time are all released.
+	q.Put("c", time.Second)
+	q.Put("d", time.Second)
+	clock.WaitForQuiescence(time.Second)
+	clock.Run(time.Second)
+	expectTaken(t, ch, "c", kmclock.AbsTime(6*time.Second))
+	expectTaken(t, ch, "d", kmclock.AbsTime(6*time.Second))
+}
+
+func TestDelayQueueEarlierHead(t *testing.T) {
+	var clock kmclock.Simulated
+	q := NewDelayQueue(&clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q.Put("late", 10*time.Second)
+	ch := startTaker(ctx, q, &clock)
+	clock.WaitForTimers(1)
+
+	// Take is waiting for the late item, an earlier one must wake it up.
+	clock.Run(time.Second)
+	q.Put("early", time.Second)
+	clock.WaitForQuiescence(time.Second)
+	clock.Run(time.Second)
+	expectTaken(t, ch, "early", kmclock.AbsTime(2*time.Second))
+
+	clock.WaitForQuiescence(time.Second)
+	clock.Run(7 * time.Second)
+	expectNothing(t, &clock, ch)
+	clock.Run(time.Second)
+	expectTaken(t, ch, "late", kmclock.AbsTime(10*time.Second))
+}
+
+func TestDelayQueueCancelHead(t *testing.T) {
+	var clock kmclock.Simulated
+	q := NewDelayQueue(&clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, _ := q.Put("a", time.Second)
+	q.Put("b", 3*time.Second)
+	ch := startTaker(ctx, q, &clock)
+	clock.WaitForTimers(1)
+
+	if !q.Cancel(h) {
+		t.Fatal("cancel of a scheduled item failed")
+	}
+	if q.Cancel(h) {
+		t.Fatal("cancel succeeded twice")
+	}
+	clock.WaitForQuiescence(time.Second)
+	clock.Run(time.Second)
+	expectNothing(t, &clock, ch)
+	clock.Run(2 * time.Second)
+	expectTaken(t, ch, "b", kmclock.AbsTime(3*time.Second))
+}
+
+func TestDelayQueueClose(t *testing.T) {
+	var clock kmclock.Simulated
+	q := NewDelayQueue(&clock)
+
+	q.Put("a", time.Second)
+	ch := startTaker(context.Background(), q, &clock)
+	clock.WaitForTimers(1)
+
+	q.Close()
+	select {
+	case got, ok := <-ch:
+		if ok {
+			t.Fatalf("unexpected %v taken after close", got.item)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Take not woken up by Close")
+	}
+	if _, err := q.Put("b", 0); err != ErrQueueClosed {
+		t.Fatalf("put after close: have %v, want ErrQueueClosed", err)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// FairQueueConfig contains the settings of a FairQueue.
+type FairQueueConfig struct {
+	DefaultWeight int // weight of tenants without their own, defaults to 1
+	DefaultMaxLen int // length limit of tenants without their own, 0 or less is unbounded
+	// Cost returns the cost of an item for deficit round-robin. If nil, every
+	// item costs 1 and dequeueing is plain weighted round-robin.
+	Cost func(item interface{}) int
+}
+
+// fairTenant is the sub-queue of a single tenant.
+type fairTenant struct {
+	id      string
+	items   *list.List
+	weight  int
+	maxLen  int
+	custom  bool          // weight or maxLen was set explicitly, keep it when empty
+	deficit int           // cost the tenant may still spend in its current turn
+	inTurn  bool          // the current turn's quantum has been granted
+	elem    *list.Element // position in the active ring, nil while empty
+}
+
+// FairQueue is a queue shared by several tenants. Every tenant has its own
+// sub-queue and length limit, and items are dequeued by deficit round-robin
+// over the tenants with queued items, so a tenant gets a share proportional
+// to its weight no matter how much the others enqueue.
+type FairQueue struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	config  FairQueueConfig
+	tenants map[string]*fairTenant
+	active  *list.List // ring of tenants with queued items
+	length  int
+	closed  bool
+}
+
+// NewFairQueue returns a new, empty FairQueue.
+func NewFairQueue(config *FairQueueConfig) *FairQueue {
+	q := &FairQueue{
+		tenants: make(map[string]*fairTenant),
+		active:  list.New(),
+	}
+	if config != nil {
+		q.config = *config
+	}
+	if q.config.DefaultWeight <= 0 {
+		q.config.DefaultWeight = 1
+	}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// Append adds item to the sub-queue of tenant. It returns ErrQueueFull if the
+// tenant reached its length limit.
+func (q *FairQueue) Append(tenant string, item interface{}) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	t := q.tenant(tenant)
+	if t.maxLen > 0 && t.items.Len() >= t.maxLen {
+		return ErrQueueFull
+	}
+	t.items.PushBack(item)
+	if t.elem == nil {
+		t.elem = q.active.PushBack(t)
+	}
+	q.length++
+	q.cond.Signal()
+	return nil
+}
+
+// Take removes the next item in round-robin order, blocking while the queue is
+// empty. It returns the item's tenant along with it.
+func (q *FairQueue) Take(ctx context.Context) (string, interface{}, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := waitFor(ctx, q.cond, &q.closed, func() bool { return q.length > 0 }); err != nil {
+		return "", nil, err
+	}
+	tenant, item := q.dequeue()
+	return tenant, item, nil
+}
+
+// TryTake removes the next item in round-robin order without blocking. The
+// last return value is false if the queue is empty.
+func (q *FairQueue) TryTake() (string, interface{}, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.length == 0 {
+		return "", nil, false
+	}
+	tenant, item := q.dequeue()
+	return tenant, item, true
+}
+
+// SetWeight changes the weight of tenant. It takes effect from the tenant's
+// next turn.
+func (q *FairQueue) SetWeight(tenant string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	t := q.tenant(tenant)
+	t.weight = weight
+	t.custom = true
+}
+
+// SetTenantMaxLen changes the length limit of tenant, 0 or less is unbounded.
+// Items already queued beyond the new limit are kept.
+func (q *FairQueue) SetTenantMaxLen(tenant string, maxLen int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	t := q.tenant(tenant)
+	t.maxLen = maxLen
+	t.custom = true
+}
+
+// Len returns the number of items queued over all tenants.
+func (q *FairQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.length
+}
+
+// TenantLen returns the number of items queued for tenant.
+func (q *FairQueue) TenantLen(tenant string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if t, ok := q.tenants[tenant]; ok {
+		return t.items.Len()
+	}
+	return 0
+}
+
+// Depths returns the number of queued items of every tenant with a non-empty
+// sub-queue, e.g. for exporting them as gauges.
+func (q *FairQueue) Depths() map[string]int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	depths := make(map[string]int, q.active.Len())
+	for e := q.active.Front(); e != nil; e = e.Next() {
+		t := e.Value.(*fairTenant)
+		depths[t.id] = t.items.Len()
+	}
+	return depths
+}
+
+// Close marks the queue as closed and wakes up all blocked callers. Remaining
+// items can still be taken.
+func (q *FairQueue) Close() {
+	q.lock.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.lock.Unlock()
+}
+
+// tenant returns the sub-queue of id, creating it if needed. It must be called
+// with q.lock held.
+func (q *FairQueue) tenant(id string) *fairTenant {
+	t, ok := q.tenants[id]
+	if !ok {
+		t = &fairTenant{
+			id:     id,
+			items:  list.New(),
+			weight: q.config.DefaultWeight,
+			maxLen: q.config.DefaultMaxLen,
+		}
+		q.tenants[id] = t
+	}
+	return t
+}
+
+// dequeue runs deficit round-robin until an item can be handed out. Every
+// tenant is granted its weight as quantum at the start of its turn and keeps
+// the turn while its deficit covers the cost of its next item. It must be
+// called with q.lock held on a non-empty queue.
+func (q *FairQueue) dequeue() (string, interface{}) {
+	for {
+		e := q.active.Front()
+		t := e.Value.(*fairTenant)
+		if !t.inTurn {
+			t.deficit += t.weight
+			t.inTurn = true
+		}
+		front := t.items.Front()
+		cost := q.cost(front.Value)
+		if t.deficit < cost {
+			// Out of quantum, carry the deficit over to the next round.
+			t.inTurn = false
+			q.active.MoveToBack(e)
+			continue
+		}
+		t.deficit -= cost
+
+		t.items.Remove(front)
+		q.length--
+		if t.items.Len() == 0 {
+			t.deficit, t.inTurn = 0, false
+			q.active.Remove(e)
+			t.elem = nil
+			if !t.custom {
+				delete(q.tenants, t.id)
+			}
+		}
+		return t.id, front.Value
+	}
+}
+
+// cost returns the deficit round-robin cost of item.
+func (q *FairQueue) cost(item interface{}) int {
+	if q.config.Cost == nil {
+		return 1
+	}
+	if cost := q.config.Cost(item); cost > 0 {
+		return cost
+	}
+	return 1
+}
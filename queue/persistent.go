@@ -0,0 +1,599 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kutil "github.com/wokaio/fdlib/ext/util"
+)
+
+const (
+	segmentSuffix    = ".seg"
+	commitFileName   = "commit"
+	recordHeaderSize = 6 // uint32 payload length + crc16 of the payload
+)
+
+var (
+	// ErrCorruptRecord is returned when a record fails its checksum or is cut
+	// short anywhere but at the tail of the newest segment.
+	ErrCorruptRecord = errors.New("persistent queue: corrupt record")
+	// ErrRecordTooLarge is returned when appending a payload that doesn't fit
+	// in a record.
+	ErrRecordTooLarge = errors.New("persistent queue: record too large")
+)
+
+// SyncPolicy controls when a PersistentQueue fsyncs its files.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every append and acknowledgement.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs on append or acknowledgement when at least
+	// SyncInterval has passed since the previous fsync.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+// PersistentConfig contains the settings of a PersistentQueue.
+type PersistentConfig struct {
+	SegmentSize  int64         // size after which a new segment file is started
+	Sync         SyncPolicy    // fsync policy
+	SyncInterval time.Duration // minimum time between fsyncs with SyncInterval
+}
+
+// DefaultPersistentConfig is used when NewPersistentQueue is given a nil config.
+var DefaultPersistentConfig = PersistentConfig{
+	SegmentSize:  64 * 1024 * 1024,
+	Sync:         SyncInterval,
+	SyncInterval: time.Second,
+}
+
+// Record is an item taken from a PersistentQueue. Its Offset is passed to Ack
+// once the item has been processed.
+type Record struct {
+	Offset uint64
+	Data   []byte
+}
+
+// segment is an append-only file holding the records starting at offset base.
+type segment struct {
+	base  uint64
+	count uint64
+	size  int64
+	path  string
+}
+
+// PersistentQueue is a durable FIFO queue backed by segmented append-only files
+// in a directory. Every record is stored with a CRC16 checksum. Consumers
+// acknowledge records by offset, segments that only contain acknowledged
+// records are deleted, and records that were taken but not acknowledged are
+// delivered again after a restart.
+//
+// PersistentQueue deliberately doesn't implement Queue: it stores byte
+// payloads rather than arbitrary values, every operation does I/O which may
+// fail while Offer and TryTake can't report errors, and a taken Record must be
+// acknowledged by its offset, which TryTake has no way to hand out.
+type PersistentQueue struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	dir    string
+	config PersistentConfig
+
+	segments []*segment
+	writer   *os.File // appends to the last segment
+	reader   *os.File // reads from segments[readSeg]
+	readSeg  int
+	readPos  int64
+
+	readOffset  uint64 // next offset to hand out
+	writeOffset uint64 // offset of the next appended record
+	committed   uint64 // every offset below has been acknowledged
+	lastSync    time.Time
+	closed      bool
+	failed      error // set when a torn write couldn't be cut off, stops appends
+}
+
+// NewPersistentQueue opens the queue stored in dir, creating it if needed.
+// Records cut short by a crash at the tail of the newest segment are dropped,
+// and delivery resumes at the first unacknowledged record.
+func NewPersistentQueue(dir string, config *PersistentConfig) (*PersistentQueue, error) {
+	if config == nil {
+		config = &DefaultPersistentConfig
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &PersistentQueue{dir: dir, config: *config, lastSync: time.Now()}
+	q.cond = sync.NewCond(&q.lock)
+
+	committed, err := q.readCommit()
+	if err != nil {
+		return nil, err
+	}
+	if err := q.loadSegments(); err != nil {
+		return nil, err
+	}
+	if len(q.segments) == 0 {
+		seg, f, err := q.createSegment(committed)
+		if err != nil {
+			return nil, err
+		}
+		q.segments, q.writer = append(q.segments, seg), f
+	}
+	last := q.segments[len(q.segments)-1]
+	q.writeOffset = last.base + last.count
+	if committed < q.segments[0].base {
+		committed = q.segments[0].base
+	}
+	if committed > q.writeOffset {
+		committed = q.writeOffset
+	}
+	q.committed = committed
+
+	if q.writer == nil {
+		if q.writer, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return nil, err
+		}
+	}
+	if err := q.seek(committed); err != nil {
+		q.closeFiles()
+		return nil, err
+	}
+	if err := q.collect(); err != nil {
+		q.closeFiles()
+		return nil, err
+	}
+	return q, nil
+}
+
+// Append writes data to the end of the queue. If a failed write leaves part
+// of the record behind and it can't be cut off, the queue refuses further
+// appends with the same error, as they would land behind the torn record.
+func (q *PersistentQueue) Append(data []byte) error {
+	if uint64(len(data)) > math.MaxUint32 {
+		return ErrRecordTooLarge
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if q.failed != nil {
+		return q.failed
+	}
+	active := q.segments[len(q.segments)-1]
+	if active.count > 0 && active.size >= q.config.SegmentSize {
+		if err := q.roll(); err != nil {
+			return err
+		}
+		active = q.segments[len(q.segments)-1]
+	}
+
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:recordHeaderSize], kutil.ChecksumCrc16(data))
+	copy(record[recordHeaderSize:], data)
+	if _, err := q.writer.Write(record); err != nil {
+		// The segment is opened for appending, so after cutting off what
+		// made it to the file the next record lands where this one should.
+		if terr := q.writer.Truncate(active.size); terr != nil {
+			q.failed = fmt.Errorf("persistent queue: torn record in %s: %w", active.path, err)
+		}
+		return err
+	}
+	active.count++
+	active.size += int64(len(record))
+	q.writeOffset++
+	q.cond.Signal()
+
+	return q.maybeSync(q.writer)
+}
+
+// Remove takes the next record from the queue, blocking until one is
+// available. It returns ErrQueueClosed once the queue is closed.
+func (q *PersistentQueue) Remove() (*Record, error) {
+	return q.Take(context.Background())
+}
+
+// Take takes the next record from the queue, blocking until one is available
+// or ctx is done.
+func (q *PersistentQueue) Take(ctx context.Context) (*Record, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := waitFor(ctx, q.cond, &q.closed, func() bool { return q.readOffset < q.writeOffset }); err != nil {
+		return nil, err
+	}
+	if q.closed { // the files are gone, even if records are left
+		return nil, ErrQueueClosed
+	}
+	if err := q.advanceReader(); err != nil {
+		return nil, err
+	}
+	data, n, err := readRecord(q.reader, q.readPos, q.segments[q.readSeg].size)
+	if err != nil {
+		return nil, err
+	}
+	record := &Record{Offset: q.readOffset, Data: data}
+	q.readPos += n
+	q.readOffset++
+	return record, nil
+}
+
+// Ack acknowledges every record up to and including offset. Acknowledged
+// records are not delivered again after a restart, and segments holding only
+// acknowledged records are deleted.
+func (q *PersistentQueue) Ack(offset uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if offset >= q.readOffset {
+		return fmt.Errorf("persistent queue: offset %d has not been taken yet", offset)
+	}
+	if offset < q.committed {
+		return nil
+	}
+	q.committed = offset + 1
+	if err := q.writeCommit(); err != nil {
+		return err
+	}
+	return q.collect()
+}
+
+// Len returns the number of records which haven't been taken yet.
+func (q *PersistentQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return int(q.writeOffset - q.readOffset)
+}
+
+// Pending returns the number of records taken but not acknowledged yet.
+func (q *PersistentQueue) Pending() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return int(q.readOffset - q.committed)
+}
+
+// Sync flushes the active segment to stable storage regardless of the policy.
+func (q *PersistentQueue) Sync() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	q.lastSync = time.Now()
+	return q.writer.Sync()
+}
+
+// Close flushes and closes the queue files and wakes up blocked consumers.
+func (q *PersistentQueue) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.cond.Broadcast()
+
+	var err error
+	if q.config.Sync != SyncNever {
+		err = q.writer.Sync()
+	}
+	if cerr := q.closeFiles(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// loadSegments scans the segment files in the queue directory, truncating a
+// partially written record at the tail of the newest one.
+func (q *PersistentQueue) loadSegments() error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, &segment{base: base, path: filepath.Join(q.dir, name)})
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].base < q.segments[j].base })
+
+	for i, seg := range q.segments {
+		last := i == len(q.segments)-1
+		if err := seg.scan(last); err != nil {
+			return err
+		}
+		if !last && seg.base+seg.count != q.segments[i+1].base {
+			return fmt.Errorf("persistent queue: segment %s has %d records, want %d",
+				seg.path, seg.count, q.segments[i+1].base-seg.base)
+		}
+	}
+	return nil
+}
+
+// scan counts the intact records of the segment. If tail is set, a damaged
+// record and everything behind it is cut off instead of failing.
+func (s *segment) scan(tail bool) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	for s.size < info.Size() {
+		_, n, err := readRecord(f, s.size, info.Size())
+		if err == ErrCorruptRecord && tail {
+			return os.Truncate(s.path, s.size)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.path, err)
+		}
+		s.count++
+		s.size += n
+	}
+	return nil
+}
+
+// readRecord reads the record at pos of a segment whose intact data ends at limit.
+func readRecord(f *os.File, pos int64, limit int64) ([]byte, int64, error) {
+	var header [recordHeaderSize]byte
+	if pos+recordHeaderSize > limit {
+		return nil, 0, ErrCorruptRecord
+	}
+	if _, err := f.ReadAt(header[:], pos); err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.LittleEndian.Uint32(header[:4]))
+	if pos+recordHeaderSize+length > limit {
+		return nil, 0, ErrCorruptRecord
+	}
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, pos+recordHeaderSize); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	if kutil.ValidateCrc16(data, header[4:]) != nil {
+		return nil, 0, ErrCorruptRecord
+	}
+	return data, recordHeaderSize + length, nil
+}
+
+// createSegment creates a new, empty segment file for records from offset
+// base on and opens it for appending.
+func (q *PersistentQueue) createSegment(base uint64) (*segment, *os.File, error) {
+	path := filepath.Join(q.dir, fmt.Sprintf("%020d%s", base, segmentSuffix))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if q.config.Sync != SyncNever {
+		if err := q.syncDir(); err != nil {
+			// Don't leave a segment behind which the next restart would
+			// find in the middle of the records.
+			f.Close()
+			os.Remove(path)
+			return nil, nil, err
+		}
+	}
+	return &segment{base: base, path: path}, f, nil
+}
+
+// roll seals the active segment and starts a new one. The active segment
+// stays the append target unless the new one could be created.
+func (q *PersistentQueue) roll() error {
+	if q.config.Sync != SyncNever {
+		if err := q.writer.Sync(); err != nil {
+			return err
+		}
+	}
+	seg, f, err := q.createSegment(q.writeOffset)
+	if err != nil {
+		return err
+	}
+	old := q.writer
+	q.segments, q.writer = append(q.segments, seg), f
+	return old.Close()
+}
+
+// seek positions the reader at offset.
+func (q *PersistentQueue) seek(offset uint64) error {
+	q.readSeg = sort.Search(len(q.segments), func(i int) bool { return q.segments[i].base > offset }) - 1
+	if q.readSeg < 0 {
+		q.readSeg = 0
+	}
+	seg := q.segments[q.readSeg]
+	reader, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	q.reader, q.readPos, q.readOffset = reader, 0, seg.base
+
+	for q.readOffset < offset {
+		_, n, err := readRecord(q.reader, q.readPos, seg.size)
+		if err != nil {
+			return err
+		}
+		q.readPos += n
+		q.readOffset++
+	}
+	return nil
+}
+
+// advanceReader moves the reader to the next segment once the current one is
+// used up.
+func (q *PersistentQueue) advanceReader() error {
+	for q.readSeg < len(q.segments)-1 && q.readPos >= q.segments[q.readSeg].size {
+		reader, err := os.Open(q.segments[q.readSeg+1].path)
+		if err != nil {
+			return err
+		}
+		q.reader.Close()
+		q.reader, q.readSeg, q.readPos = reader, q.readSeg+1, 0
+	}
+	return nil
+}
+
+// collect deletes the segments whose records have all been acknowledged.
+func (q *PersistentQueue) collect() error {
+	if err := q.advanceReader(); err != nil {
+		return err
+	}
+	n := 0
+	for n < q.readSeg && q.segments[n+1].base <= q.committed {
+		if err := os.Remove(q.segments[n].path); err != nil {
+			return err
+		}
+		n++
+	}
+	q.segments = q.segments[n:]
+	q.readSeg -= n
+	if n > 0 && q.config.Sync != SyncNever {
+		return q.syncDir()
+	}
+	return nil
+}
+
+// readCommit loads the committed offset, which is zero for a new queue.
+func (q *PersistentQueue) readCommit() (uint64, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(q.dir, commitFileName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) != 10 || kutil.ValidateCrc16(buf[:8], buf[8:]) != nil {
+		return 0, fmt.Errorf("persistent queue: corrupt commit file: %w", ErrCorruptRecord)
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// writeCommit atomically replaces the commit file with the committed offset.
+func (q *PersistentQueue) writeCommit() error {
+	buf := make([]byte, 8, 10)
+	binary.LittleEndian.PutUint64(buf, q.committed)
+	buf = append(buf, kutil.ChecksumCrc16(buf)...)
+
+	tmp := filepath.Join(q.dir, commitFileName+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	sync := q.syncDue()
+	if sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, commitFileName)); err != nil {
+		return err
+	}
+	if sync {
+		return q.syncDir()
+	}
+	return nil
+}
+
+// syncDue reports whether the sync policy asks for an fsync now, and if so
+// starts a new sync interval.
+func (q *PersistentQueue) syncDue() bool {
+	switch q.config.Sync {
+	case SyncAlways:
+	case SyncInterval:
+		if time.Since(q.lastSync) < q.config.SyncInterval {
+			return false
+		}
+	default:
+		return false
+	}
+	q.lastSync = time.Now()
+	return true
+}
+
+// maybeSync fsyncs f according to the sync policy.
+func (q *PersistentQueue) maybeSync(f *os.File) error {
+	if !q.syncDue() {
+		return nil
+	}
+	return f.Sync()
+}
+
+// syncDir fsyncs the queue directory, so that files created, renamed or
+// removed in it survive a crash.
+func (q *PersistentQueue) syncDir() error {
+	d, err := os.Open(q.dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (q *PersistentQueue) closeFiles() error {
+	var err error
+	if q.reader != nil {
+		err = q.reader.Close()
+	}
+	if q.writer != nil {
+		if werr := q.writer.Close(); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+// Queue is the non-blocking FIFO surface shared by the queue implementations,
+// so producers and consumers can switch between them.
+type Queue interface {
+	// Append adds item to the back of the queue or returns ErrQueueFull.
+	Append(item interface{}) error
+	// Offer adds item to the back of the queue, reporting whether it fit.
+	Offer(item interface{}) bool
+	// TryTake removes the item at the front of the queue, if there is one.
+	TryTake() (interface{}, bool)
+	// Len returns the number of queued items.
+	Len() int
+}
+
+var (
+	_ Queue = (*SimpleQueue)(nil)
+	_ Queue = (*SPSCRing)(nil)
+	_ Queue = (*MPMCRing)(nil)
+)
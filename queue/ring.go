@@ -0,0 +1,217 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"sync/atomic"
+)
+
+// cacheLinePad keeps the producer and consumer cursors on separate cache lines
+// so they don't false-share.
+type cacheLinePad [64]byte
+
+// ringSize rounds capacity up to the next power of two, with a minimum of two.
+// It panics if capacity is above 1<<62.
+func ringSize(capacity int) uint64 {
+	if capacity <= 2 {
+		return 2
+	}
+	if uint64(capacity) > 1<<62 {
+		panic("queue: ring capacity too large")
+	}
+	size := uint64(2)
+	for size < uint64(capacity) {
+		size <<= 1
+	}
+	return size
+}
+
+// SPSCRing is a fixed-capacity lock-free ring buffer for exactly one producer
+// goroutine and one consumer goroutine. Using it from more than one producer or
+// more than one consumer at a time corrupts the queue, use MPMCRing for that.
+type SPSCRing struct {
+	_    cacheLinePad
+	head uint64 // next slot to read, only advanced by the consumer
+	_    cacheLinePad
+	tail uint64 // next slot to write, only advanced by the producer
+	_    cacheLinePad
+	mask uint64
+	buf  []interface{}
+}
+
+// NewSPSCRing returns a new, empty SPSCRing. The capacity is rounded up to a
+// power of two.
+func NewSPSCRing(capacity int) *SPSCRing {
+	size := ringSize(capacity)
+	return &SPSCRing{
+		mask: size - 1,
+		buf:  make([]interface{}, size),
+	}
+}
+
+// Append adds item to the ring, returning ErrQueueFull if there is no room.
+func (r *SPSCRing) Append(item interface{}) error {
+	if !r.Offer(item) {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// Offer adds item to the ring, reporting whether there was room for it.
+func (r *SPSCRing) Offer(item interface{}) bool {
+	tail := atomic.LoadUint64(&r.tail)
+	if tail-atomic.LoadUint64(&r.head) > r.mask {
+		return false
+	}
+	r.buf[tail&r.mask] = item
+	atomic.StoreUint64(&r.tail, tail+1) // publish the slot to the consumer
+	return true
+}
+
+// TryTake removes the oldest item from the ring. The second return value is
+// false if the ring is empty.
+func (r *SPSCRing) TryTake() (interface{}, bool) {
+	head := atomic.LoadUint64(&r.head)
+	if head == atomic.LoadUint64(&r.tail) {
+		return nil, false
+	}
+	slot := &r.buf[head&r.mask]
+	item := *slot
+	*slot = nil                         // don't pin the item until the slot is reused
+	atomic.StoreUint64(&r.head, head+1) // hand the slot back to the producer
+	return item, true
+}
+
+// Len returns the number of items in the ring.
+func (r *SPSCRing) Len() int {
+	head := atomic.LoadUint64(&r.head)
+	return int(atomic.LoadUint64(&r.tail) - head)
+}
+
+// Cap returns the capacity of the ring.
+func (r *SPSCRing) Cap() int {
+	return len(r.buf)
+}
+
+// mpmcCell is a ring slot guarded by a sequence number, which tells producers
+// and consumers whose turn it is to use the slot.
+type mpmcCell struct {
+	seq  uint64
+	item interface{}
+}
+
+// MPMCRing is a fixed-capacity lock-free ring buffer which may be used by any
+// number of producers and consumers concurrently. It implements Dmitry
+// Vyukov's bounded MPMC queue: each slot carries a sequence number and the
+// enqueue and dequeue cursors are claimed with a CAS.
+type MPMCRing struct {
+	_     cacheLinePad
+	enq   uint64 // next position to claim for writing
+	_     cacheLinePad
+	deq   uint64 // next position to claim for reading
+	_     cacheLinePad
+	mask  uint64
+	cells []mpmcCell
+}
+
+// NewMPMCRing returns a new, empty MPMCRing. The capacity is rounded up to a
+// power of two.
+func NewMPMCRing(capacity int) *MPMCRing {
+	size := ringSize(capacity)
+	r := &MPMCRing{
+		mask:  size - 1,
+		cells: make([]mpmcCell, size),
+	}
+	for i := range r.cells {
+		r.cells[i].seq = uint64(i)
+	}
+	return r
+}
+
+// Append adds item to the ring, returning ErrQueueFull if there is no room.
+func (r *MPMCRing) Append(item interface{}) error {
+	if !r.Offer(item) {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// Offer adds item to the ring, reporting whether there was room for it.
+func (r *MPMCRing) Offer(item interface{}) bool {
+	pos := atomic.LoadUint64(&r.enq)
+	for {
+		cell := &r.cells[pos&r.mask]
+		seq := atomic.LoadUint64(&cell.seq)
+		switch dif := int64(seq - pos); {
+		case dif == 0:
+			// The slot is free for this lap, try to claim it.
+			if atomic.CompareAndSwapUint64(&r.enq, pos, pos+1) {
+				cell.item = item
+				atomic.StoreUint64(&cell.seq, pos+1)
+				return true
+			}
+			pos = atomic.LoadUint64(&r.enq)
+		case dif < 0:
+			// The slot still holds an item from the previous lap.
+			return false
+		default:
+			// Another producer claimed the slot, catch up.
+			pos = atomic.LoadUint64(&r.enq)
+		}
+	}
+}
+
+// TryTake removes the oldest item from the ring. The second return value is
+// false if the ring is empty.
+func (r *MPMCRing) TryTake() (interface{}, bool) {
+	pos := atomic.LoadUint64(&r.deq)
+	for {
+		cell := &r.cells[pos&r.mask]
+		seq := atomic.LoadUint64(&cell.seq)
+		switch dif := int64(seq - (pos + 1)); {
+		case dif == 0:
+			// The slot was published for this lap, try to claim it.
+			if atomic.CompareAndSwapUint64(&r.deq, pos, pos+1) {
+				item := cell.item
+				cell.item = nil
+				atomic.StoreUint64(&cell.seq, pos+r.mask+1)
+				return item, true
+			}
+			pos = atomic.LoadUint64(&r.deq)
+		case dif < 0:
+			// Nothing has been written to the slot yet.
+			return nil, false
+		default:
+			// Another consumer claimed the slot, catch up.
+			pos = atomic.LoadUint64(&r.deq)
+		}
+	}
+}
+
+// Len returns the number of items in the ring. With concurrent producers and
+// consumers the result is only a snapshot.
+func (r *MPMCRing) Len() int {
+	deq := atomic.LoadUint64(&r.deq)
+	enq := atomic.LoadUint64(&r.enq)
+	if enq < deq {
+		return 0
+	}
+	return int(enq - deq)
+}
+
+// Cap returns the capacity of the ring.
+func (r *MPMCRing) Cap() int {
+	return len(r.cells)
+}
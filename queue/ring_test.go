@@ -0,0 +1,249 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestRingSize(t *testing.T) {
+	for _, c := range []struct {
+		capacity int
+		want     uint64
+	}{{-1, 2}, {0, 2}, {2, 2}, {3, 4}, {1000, 1024}, {1 << 62, 1 << 62}} {
+		if have := ringSize(c.capacity); have != c.want {
+			t.Errorf("capacity %d: have size %d, want %d", c.capacity, have, c.want)
+		}
+	}
+}
+
+func TestSPSCRingFullAndEmpty(t *testing.T) {
+	r := NewSPSCRing(3)
+	if r.Cap() != 4 {
+		t.Fatalf("capacity not rounded up: have %d, want 4", r.Cap())
+	}
+	if _, ok := r.TryTake(); ok {
+		t.Fatal("took an item from an empty ring")
+	}
+	for i := 0; i < 4; i++ {
+		if err := r.Append(i); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+	}
+	if err := r.Append(4); err != ErrQueueFull {
+		t.Fatalf("append to a full ring: have %v, want ErrQueueFull", err)
+	}
+	for i := 0; i < 4; i++ {
+		if item, ok := r.TryTake(); !ok || item != i {
+			t.Fatalf("take %d: have %v, %v", i, item, ok)
+		}
+	}
+	if r.Len() != 0 {
+		t.Fatalf("length after draining: have %d, want 0", r.Len())
+	}
+}
+
+func TestMPMCRingFullAndEmpty(t *testing.T) {
+	r := NewMPMCRing(2)
+	if _, ok := r.TryTake(); ok {
+		t.Fatal("took an item from an empty ring")
+	}
+	if !r.Offer(1) || !r.Offer(2) {
+		t.Fatal("ring refused items below its capacity")
+	}
+	if r.Offer(3) {
+		t.Fatal("full ring accepted an item")
+	}
+	if item, ok := r.TryTake(); !ok || item != 1 {
+		t.Fatalf("take: have %v, %v, want 1", item, ok)
+	}
+	if !r.Offer(3) {
+		t.Fatal("ring refused an item after a take")
+	}
+	if r.Len() != 2 {
+		t.Fatalf("length: have %d, want 2", r.Len())
+	}
+}
+
+func TestSPSCRingConcurrent(t *testing.T) {
+	const items = 100000
+	r := NewSPSCRing(64)
+
+	go func() {
+		for i := 0; i < items; i++ {
+			for !r.Offer(i) {
+				runtime.Gosched()
+			}
+		}
+	}()
+	for want := 0; want < items; {
+		item, ok := r.TryTake()
+		if !ok {
+			runtime.Gosched()
+			continue
+		}
+		if item != want {
+			t.Fatalf("out of order: have %v, want %d", item, want)
+		}
+		want++
+	}
+}
+
+func TestMPMCRingConcurrent(t *testing.T) {
+	const (
+		producers = 4
+		consumers = 4
+		perProd   = 20000
+	)
+	r := NewMPMCRing(128)
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProd; i++ {
+				for !r.Offer(p*perProd + i) {
+					runtime.Gosched()
+				}
+			}
+		}(p)
+	}
+
+	var (
+		lock sync.Mutex
+		seen = make([]int, producers*perProd)
+		left = producers * perProd
+	)
+	var cwg sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			last := make([]int, producers) // per producer, items are taken in order
+			for i := range last {
+				last[i] = -1
+			}
+			for {
+				lock.Lock()
+				done := left == 0
+				lock.Unlock()
+				if done {
+					return
+				}
+				item, ok := r.TryTake()
+				if !ok {
+					runtime.Gosched()
+					continue
+				}
+				v := item.(int)
+				p, i := v/perProd, v%perProd
+				if i <= last[p] {
+					t.Errorf("producer %d: item %d taken after %d", p, i, last[p])
+				}
+				last[p] = i
+
+				lock.Lock()
+				seen[v]++
+				left--
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	cwg.Wait()
+
+	for v, n := range seen {
+		if n != 1 {
+			t.Fatalf("item %d taken %d times", v, n)
+		}
+	}
+	if r.Len() != 0 {
+		t.Fatalf("ring not empty: %d items left", r.Len())
+	}
+}
+
+// benchmarkQueue alternates adding and taking items from parallel goroutines
+func benchmarkQueue(b *testing.B, q Queue) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for !q.Offer(1) {
+				runtime.Gosched()
+			}
+			for {
+				if _, ok := q.TryTake(); ok {
+					break
+				}
+				runtime.Gosched()
+			}
+		}
+	})
+}
+
+func BenchmarkMPMCRing(b *testing.B) {
+	benchmarkQueue(b, NewMPMCRing(1024))
+}
+
+func BenchmarkSimpleQueue(b *testing.B) {
+	benchmarkQueue(b, NewSimpleQueue(1024))
+}
+
+func BenchmarkSPSCRing(b *testing.B) {
+	r := NewSPSCRing(1024)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for taken := 0; taken < b.N; {
+			if _, ok := r.TryTake(); ok {
+				taken++
+			} else {
+				runtime.Gosched()
+			}
+		}
+	}()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for !r.Offer(1) {
+			runtime.Gosched()
+		}
+	}
+	<-done
+}
+
+func BenchmarkSimpleQueueSingleProducer(b *testing.B) {
+	q := NewSimpleQueue(1024)
+	q.SetMaxLen(1024)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for taken := 0; taken < b.N; {
+			if _, ok := q.TryTake(); ok {
+				taken++
+			} else {
+				runtime.Gosched()
+			}
+		}
+	}()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for !q.Offer(1) {
+			runtime.Gosched()
+		}
+	}
+	<-done
+}
@@ -16,21 +16,35 @@ package queue
 
 import (
 	"container/list"
+	"context"
 	"errors"
 	"sync"
+	"time"
+
+	"github.com/wokaio/fdlib/internal/syncutil"
+)
+
+var (
+	// ErrQueueFull is returned when an item is appended to a queue at its maximum length.
+	ErrQueueFull = errors.New("queue is full")
+	// ErrQueueClosed is returned by blocking and appending calls once the queue is closed.
+	ErrQueueClosed = errors.New("queue is closed")
 )
 
 type SimpleQueue struct {
-	lock   sync.Mutex
-	cond   *sync.Cond
-	queue  *list.List
-	maxLen int
+	lock    sync.Mutex
+	cond    *sync.Cond // signalled when an item is added
+	notFull *sync.Cond // signalled when an item is removed
+	queue   *list.List
+	maxLen  int
+	closed  bool
 }
 
 // NewSimpleQueue returns a new, empty SimpleQueue
 func NewSimpleQueue(capacity int) *SimpleQueue {
 	q := new(SimpleQueue)
 	q.cond = sync.NewCond(&q.lock)
+	q.notFull = sync.NewCond(&q.lock)
 	q.queue = list.New()
 	q.maxLen = -1
 	return q
@@ -40,6 +54,7 @@ func NewSimpleQueue(capacity int) *SimpleQueue {
 func (q *SimpleQueue) SetMaxLen(maxLen int) {
 	q.lock.Lock()
 	q.maxLen = maxLen
+	q.notFull.Broadcast()
 	q.lock.Unlock()
 }
 
@@ -49,8 +64,10 @@ func (q *SimpleQueue) Append(item interface{}) error {
 
 	q.cond.L.Lock()
 
-	if q.maxLen != -1 && q.queue.Len() >= q.maxLen {
-		err = errors.New("SimpleQueue is full")
+	if q.closed {
+		err = ErrQueueClosed
+	} else if q.full() {
+		err = ErrQueueFull
 	} else {
 		q.queue.PushBack(item)
 		q.cond.Signal()
@@ -61,20 +78,147 @@ func (q *SimpleQueue) Append(item interface{}) error {
 	return err
 }
 
-/* Remove item from the queue */
+// AppendAll appends all items to the queue at once. Either every item is added
+// or, if they don't all fit within the maximum length, none is and ErrQueueFull
+// is returned.
+func (q *SimpleQueue) AppendAll(items []interface{}) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if q.maxLen != -1 && q.queue.Len()+len(items) > q.maxLen {
+		return ErrQueueFull
+	}
+	for _, item := range items {
+		q.queue.PushBack(item)
+	}
+	if len(items) > 0 {
+		q.cond.Broadcast()
+	}
+	return nil
+}
+
+// Offer appends item if the queue has room, reporting whether it was added.
+func (q *SimpleQueue) Offer(item interface{}) bool {
+	return q.Append(item) == nil
+}
+
+// Put appends item to the queue, blocking while the queue is full. It returns
+// the context error if ctx is done first, or ErrQueueClosed if the queue is closed.
+func (q *SimpleQueue) Put(ctx context.Context, item interface{}) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if err := waitFor(ctx, q.notFull, &q.closed, func() bool { return !q.full() }); err != nil {
+		return err
+	}
+	// Close may have woken us up after a consumer freed a slot
+	if q.closed {
+		return ErrQueueClosed
+	}
+	q.queue.PushBack(item)
+	q.cond.Signal()
+	return nil
+}
+
+// Remove item from the queue, blocking until one is available. Once the queue
+// is closed and drained it returns nil instead of blocking forever.
 func (q *SimpleQueue) Remove() interface{} {
-	q.cond.L.Lock()
+	item, _ := q.Take(context.Background())
+	return item
+}
 
-	for q.queue.Len() == 0 {
-		q.cond.Wait()
+// Take removes the item at the front of the queue, blocking while the queue is
+// empty. It returns the context error if ctx is done first. Items still queued
+// when the queue is closed are handed out before ErrQueueClosed is returned.
+func (q *SimpleQueue) Take(ctx context.Context) (interface{}, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := waitFor(ctx, q.cond, &q.closed, func() bool { return q.queue.Len() > 0 }); err != nil {
+		return nil, err
 	}
+	return q.pop(), nil
+}
 
-	item := q.queue.Front()
-	q.queue.Remove(item)
+// TryTake removes the item at the front of the queue without blocking. The
+// second return value is false if the queue is empty.
+func (q *SimpleQueue) TryTake() (interface{}, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
 
-	q.cond.L.Unlock()
+	if q.queue.Len() == 0 {
+		return nil, false
+	}
+	return q.pop(), true
+}
 
-	return item.Value
+// Peek returns the item at the front of the queue without removing it. The
+// second return value is false if the queue is empty.
+func (q *SimpleQueue) Peek() (interface{}, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if item := q.queue.Front(); item != nil {
+		return item.Value, true
+	}
+	return nil, false
+}
+
+// DrainTo removes up to max items from the queue without blocking and appends
+// them to buf, returning the extended slice. A max of zero or less drains the
+// whole queue.
+func (q *SimpleQueue) DrainTo(buf []interface{}, max int) []interface{} {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.drain(buf, max)
+}
+
+// TakeBatch removes up to maxItems items from the queue. It blocks like Take
+// until the first item is available, then lingers for at most maxWait to let the
+// batch fill up. Items already removed are always returned: if the queue is
+// closed or ctx is done while lingering, the partial batch comes back with a
+// nil error and the condition is reported by the next call.
+func (q *SimpleQueue) TakeBatch(ctx context.Context, maxItems int, maxWait time.Duration) ([]interface{}, error) {
+	if maxItems <= 0 {
+		maxItems = 1
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := waitFor(ctx, q.cond, &q.closed, func() bool { return q.queue.Len() > 0 }); err != nil {
+		return nil, err
+	}
+	batch := q.drain(make([]interface{}, 0, maxItems), maxItems)
+	if len(batch) == maxItems || maxWait <= 0 {
+		return batch, nil
+	}
+
+	linger, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+	for len(batch) < maxItems {
+		if waitFor(linger, q.cond, &q.closed, func() bool { return q.queue.Len() > 0 }) != nil {
+			break
+		}
+		batch = q.drain(batch, maxItems-len(batch))
+	}
+	return batch, nil
+}
+
+// Close marks the queue as closed and wakes up all blocked callers. Appending
+// to a closed queue fails, while remaining items can still be taken.
+func (q *SimpleQueue) Close() {
+	q.lock.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.notFull.Broadcast()
+	q.lock.Unlock()
 }
 
 /* Len of the queue */
@@ -87,3 +231,47 @@ func (q *SimpleQueue) Len() int {
 
 	return len
 }
+
+// full reports whether the queue reached its maximum length. It must be called
+// with q.lock held.
+func (q *SimpleQueue) full() bool {
+	return q.maxLen != -1 && q.queue.Len() >= q.maxLen
+}
+
+// pop removes the front item and wakes up one blocked producer. It must be
+// called with q.lock held on a non-empty queue.
+func (q *SimpleQueue) pop() interface{} {
+	item := q.queue.Front()
+	q.queue.Remove(item)
+	q.notFull.Signal()
+	return item.Value
+}
+
+// drain moves up to max items (all if max <= 0) from the queue to buf. It must
+// be called with q.lock held.
+func (q *SimpleQueue) drain(buf []interface{}, max int) []interface{} {
+	n := q.queue.Len()
+	if max > 0 && max < n {
+		n = max
+	}
+	for i := 0; i < n; i++ {
+		item := q.queue.Front()
+		q.queue.Remove(item)
+		buf = append(buf, item.Value)
+	}
+	if n > 0 {
+		q.notFull.Broadcast()
+	}
+	return buf
+}
+
+// waitFor blocks on cond until ready returns true. It gives up with
+// ErrQueueClosed once *closed is set (unless ready already holds) or with the
+// context error once ctx is done. It must be called with cond.L held.
+func waitFor(ctx context.Context, cond *sync.Cond, closed *bool, ready func() bool) error {
+	err := syncutil.WaitCond(ctx, cond, func() bool { return ready() || *closed })
+	if err == nil && !ready() {
+		return ErrQueueClosed
+	}
+	return err
+}
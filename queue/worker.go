@@ -0,0 +1,269 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
+	"github.com/wokaio/fdlib/metric"
+)
+
+// Handler processes an item taken from the queue. Returning an error makes the
+// worker pool retry the item.
+type Handler func(ctx context.Context, item interface{}) error
+
+// WorkerPoolConfig contains the settings of a WorkerPool. Zero fields take
+// their defaults.
+type WorkerPoolConfig struct {
+	Concurrency int           // number of workers, defaults to 1
+	MaxAttempts int           // handler calls per item before giving up, defaults to 3
+	BaseBackoff time.Duration // delay before the first retry, defaults to 100ms
+	MaxBackoff  time.Duration // upper bound of the retry delay, defaults to 30s
+	Jitter      float64       // fraction of each delay which is randomised, 0 to 1, more counts as 1
+	DeadLetter  *SimpleQueue  // receives a *DeadLetter for every failed or abandoned item
+	Clock       kmclock.Clock // clock for the backoff delays, defaults to kmclock.System
+	// Metrics receives the pool counters. It may be a struct registered with
+	// metric.NewMetricStats, otherwise the pool allocates its own.
+	Metrics *WorkerPoolMetrics
+}
+
+// WorkerPoolMetrics are the counters maintained by a WorkerPool.
+type WorkerPoolMetrics struct {
+	Processed *metric.CounterNumber // items handled successfully
+	Failed    *metric.CounterNumber // items given up on after their last attempt
+	Abandoned *metric.CounterNumber // items backing off when Shutdown gave up waiting
+	Retried   *metric.CounterNumber // retries of failed handler calls
+	InFlight  *metric.GaugeNumber   // items currently being handled or backing off
+	// DeadLettersDropped counts the items the dead-letter queue refused, e.g.
+	// because it was full or closed.
+	DeadLettersDropped *metric.CounterNumber
+}
+
+// DeadLetter is put on the dead-letter queue for an item which failed too
+// often, or whose retry was abandoned by Shutdown. Attempts tells them apart.
+type DeadLetter struct {
+	Item     interface{}
+	Err      error // error of the last attempt
+	Attempts int
+}
+
+// WorkerPool runs a number of workers consuming items from a SimpleQueue.
+// Failed items are retried with exponential backoff and jitter, and items
+// which run out of attempts go to the dead-letter queue.
+type WorkerPool struct {
+	queue   *SimpleQueue
+	handler Handler
+	config  WorkerPoolConfig
+	metrics *WorkerPoolMetrics
+
+	takeCtx    context.Context // canceled to stop waiting for new items
+	cancelTake context.CancelFunc
+	runCtx     context.Context // canceled to abort handlers and backoffs
+	cancelRun  context.CancelFunc
+	startOnce  sync.Once
+	wg         sync.WaitGroup
+}
+
+// NewWorkerPool creates a worker pool handling the items of queue. Call Start
+// to launch the workers.
+func NewWorkerPool(queue *SimpleQueue, handler Handler, config *WorkerPoolConfig) *WorkerPool {
+	p := &WorkerPool{queue: queue, handler: handler}
+	if config != nil {
+		p.config = *config
+	}
+	if p.config.Concurrency <= 0 {
+		p.config.Concurrency = 1
+	}
+	if p.config.MaxAttempts <= 0 {
+		p.config.MaxAttempts = 3
+	}
+	if p.config.BaseBackoff <= 0 {
+		p.config.BaseBackoff = 100 * time.Millisecond
+	}
+	if p.config.MaxBackoff <= 0 {
+		p.config.MaxBackoff = 30 * time.Second
+	}
+	if p.config.Clock == nil {
+		p.config.Clock = kmclock.System{}
+	}
+
+	p.metrics = p.config.Metrics
+	if p.metrics == nil {
+		p.metrics = new(WorkerPoolMetrics)
+	}
+	if p.metrics.Processed == nil {
+		p.metrics.Processed = new(metric.CounterNumber)
+	}
+	if p.metrics.Failed == nil {
+		p.metrics.Failed = new(metric.CounterNumber)
+	}
+	if p.metrics.Abandoned == nil {
+		p.metrics.Abandoned = new(metric.CounterNumber)
+	}
+	if p.metrics.Retried == nil {
+		p.metrics.Retried = new(metric.CounterNumber)
+	}
+	if p.metrics.InFlight == nil {
+		p.metrics.InFlight = new(metric.GaugeNumber)
+	}
+	if p.metrics.DeadLettersDropped == nil {
+		p.metrics.DeadLettersDropped = new(metric.CounterNumber)
+	}
+
+	p.takeCtx, p.cancelTake = context.WithCancel(context.Background())
+	p.runCtx, p.cancelRun = context.WithCancel(context.Background())
+	return p
+}
+
+// Start launches the workers. Calling it more than once has no effect.
+func (p *WorkerPool) Start() {
+	p.startOnce.Do(func() {
+		for i := 0; i < p.config.Concurrency; i++ {
+			p.wg.Add(1)
+			go p.work()
+		}
+	})
+}
+
+// Metrics returns the counters of the pool.
+func (p *WorkerPool) Metrics() *WorkerPoolMetrics {
+	return p.metrics
+}
+
+// Shutdown stops the workers gracefully: they stop waiting for new items but
+// finish the ones already queued. If ctx is done before the queue is drained,
+// running handlers and backoffs are canceled, and the context error is
+// returned once the workers have exited. Items canceled while backing off
+// before a retry are counted as abandoned and go to the dead-letter queue.
+// Items still queued are left in the queue.
+func (p *WorkerPool) Shutdown(ctx context.Context) error {
+	p.cancelTake()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	p.cancelRun()
+	<-done
+	return err
+}
+
+// work is the loop of a single worker.
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+
+	for p.runCtx.Err() == nil {
+		// Once draining, Take still hands out queued items but returns an
+		// error instead of blocking on an empty queue.
+		item, err := p.queue.Take(p.takeCtx)
+		if err != nil {
+			return
+		}
+		p.process(item)
+	}
+}
+
+// process handles a single item, retrying it until it succeeds or runs out of
+// attempts.
+func (p *WorkerPool) process(item interface{}) {
+	p.metrics.InFlight.Inc(1)
+	defer p.metrics.InFlight.Dec(1)
+
+	for attempt := 1; ; attempt++ {
+		err := p.handle(item)
+		if err == nil {
+			p.metrics.Processed.Inc(1)
+			return
+		}
+		if attempt >= p.config.MaxAttempts {
+			p.metrics.Failed.Inc(1)
+			p.deadLetter(item, err, attempt)
+			return
+		}
+		if !p.sleep(p.backoff(attempt)) {
+			p.metrics.Abandoned.Inc(1)
+			p.deadLetter(item, err, attempt)
+			return
+		}
+		p.metrics.Retried.Inc(1)
+	}
+}
+
+// deadLetter puts an item given up on to the dead-letter queue, if there is one
+func (p *WorkerPool) deadLetter(item interface{}, err error, attempts int) {
+	if p.config.DeadLetter == nil {
+		return
+	}
+	if p.config.DeadLetter.Append(&DeadLetter{Item: item, Err: err, Attempts: attempts}) != nil {
+		p.metrics.DeadLettersDropped.Inc(1)
+	}
+}
+
+// handle calls the handler, turning a panic into an error.
+func (p *WorkerPool) handle(item interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("worker pool: handler panic: %v", r)
+		}
+	}()
+	return p.handler(p.runCtx, item)
+}
+
+// backoff returns the delay before retrying after the given failed attempt.
+func (p *WorkerPool) backoff(attempt int) time.Duration {
+	delay := p.config.BaseBackoff
+	for i := 1; i < attempt && delay < p.config.MaxBackoff; i++ {
+		if delay > p.config.MaxBackoff/2 {
+			delay = p.config.MaxBackoff // without doubling, which could overflow
+			break
+		}
+		delay *= 2
+	}
+	if delay > p.config.MaxBackoff {
+		delay = p.config.MaxBackoff
+	}
+	if p.config.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * math.Min(p.config.Jitter, 1) * float64(delay))
+	}
+	return delay
+}
+
+// sleep waits for d on the pool clock. It returns false if the pool is
+// stopped in the meantime.
+func (p *WorkerPool) sleep(d time.Duration) bool {
+	timer := p.config.Clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return true
+	case <-p.runCtx.Done():
+		return false
+	}
+}
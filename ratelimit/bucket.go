@@ -0,0 +1,195 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
+)
+
+// TokenBucket is a limiter whose bucket holds up to burst tokens and is
+// refilled at rate tokens per second. Every event takes a token.
+type TokenBucket struct {
+	limiter
+	rate   float64
+	size   int
+	tokens float64         // may go negative for reservations in the future
+	last   kmclock.AbsTime // time tokens was last updated
+}
+
+// NewTokenBucket creates a full token bucket refilled at rate tokens per
+// second and holding at most burst tokens. A rate of math.Inf(1) admits every
+// request right away, whatever its size. A rate of zero or less never refills
+// the bucket, so only the initial burst is granted.
+func NewTokenBucket(clock kmclock.Clock, rate float64, burst int) *TokenBucket {
+	b := &TokenBucket{rate: normalizeRate(rate), size: burst, tokens: float64(burst), last: clock.Now()}
+	b.limiter = limiter{clock: clock, policy: b}
+	return b
+}
+
+// Tokens returns the number of tokens available now.
+func (b *TokenBucket) Tokens() float64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(b.clock.Now())
+	return b.tokens
+}
+
+func (b *TokenBucket) burst() int {
+	if math.IsInf(b.rate, 1) {
+		return math.MaxInt
+	}
+	return b.size
+}
+
+func (b *TokenBucket) reserve(now kmclock.AbsTime, n int, maxWait time.Duration) (kmclock.AbsTime, bool) {
+	if math.IsInf(b.rate, 1) {
+		return now, true
+	}
+	b.refill(now)
+	tokens := b.tokens - float64(n)
+	var wait time.Duration
+	if tokens < 0 {
+		if b.rate <= 0 {
+			return 0, false
+		}
+		wait = secondsToDuration(-tokens / b.rate)
+	}
+	if wait > maxWait {
+		return 0, false
+	}
+	b.tokens = tokens
+	return now.Add(wait), true
+}
+
+func (b *TokenBucket) cancel(now, at kmclock.AbsTime, n int) {
+	if math.IsInf(b.rate, 1) {
+		return
+	}
+	b.refill(now)
+	b.tokens += float64(n)
+	if b.tokens > float64(b.size) {
+		b.tokens = float64(b.size)
+	}
+}
+
+// refill adds the tokens accrued since the last update.
+func (b *TokenBucket) refill(now kmclock.AbsTime) {
+	if math.IsInf(b.rate, 1) {
+		b.tokens = float64(b.size)
+		return
+	}
+	if now > b.last {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > float64(b.size) {
+			b.tokens = float64(b.size)
+		}
+		b.last = now
+	}
+}
+
+// GCRA is a limiter using the generic cell rate algorithm. It admits the
+// same traffic as a TokenBucket with the same rate and burst, but its whole
+// state is a single timestamp, the theoretical arrival time of the next event.
+type GCRA struct {
+	limiter
+	interval  time.Duration   // emission interval, the inverse of the rate
+	size      int             // burst
+	tat       kmclock.AbsTime // theoretical arrival time
+	unlimited bool            // the rate is infinite
+	remaining int             // permits left if the rate is zero
+}
+
+// NewGCRA creates a limiter admitting rate events per second on average and
+// bursts of up to burst events. Infinite and non-positive rates behave like
+// with NewTokenBucket.
+func NewGCRA(clock kmclock.Clock, rate float64, burst int) *GCRA {
+	g := &GCRA{size: burst, tat: clock.Now(), remaining: burst}
+	switch rate = normalizeRate(rate); {
+	case math.IsInf(rate, 1):
+		g.unlimited = true
+	case rate > 0:
+		g.interval = secondsToDuration(1 / rate)
+	}
+	g.limiter = limiter{clock: clock, policy: g}
+	return g
+}
+
+func (g *GCRA) burst() int {
+	if g.unlimited {
+		return math.MaxInt
+	}
+	return g.size
+}
+
+func (g *GCRA) reserve(now kmclock.AbsTime, n int, maxWait time.Duration) (kmclock.AbsTime, bool) {
+	switch {
+	case g.unlimited:
+		return now, true
+	case g.interval <= 0:
+		// Without a rate, the permits of the burst are never replenished
+		if n > g.remaining {
+			return 0, false
+		}
+		g.remaining -= n
+		return now, true
+	}
+	tat := g.tat
+	if tat < now {
+		tat = now
+	}
+	tat = tat.Add(time.Duration(n) * g.interval)
+	at := tat.Add(-time.Duration(g.size) * g.interval)
+	if at < now {
+		at = now
+	}
+	if at.Sub(now) > maxWait {
+		return 0, false
+	}
+	g.tat = tat
+	return at, true
+}
+
+func (g *GCRA) cancel(now, at kmclock.AbsTime, n int) {
+	switch {
+	case g.unlimited:
+		return
+	case g.interval <= 0:
+		g.remaining += n
+		return
+	}
+	g.tat = g.tat.Add(-time.Duration(n) * g.interval)
+}
+
+// normalizeRate maps a NaN or negative rate to zero
+func normalizeRate(rate float64) float64 {
+	if math.IsNaN(rate) || rate < 0 {
+		return 0
+	}
+	return rate
+}
+
+// secondsToDuration converts seconds to a duration, rounding up so a wait
+// never ends too early.
+func secondsToDuration(s float64) time.Duration {
+	d := s * float64(time.Second)
+	if d >= float64(InfDuration) {
+		return InfDuration
+	}
+	return time.Duration(math.Ceil(d))
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+
+	"github.com/wokaio/fdlib/caching"
+)
+
+// Keyed keeps a separate limiter per key, e.g. per client address. Limiters
+// are created on first use and kept in an LRU cache, so the least recently
+// used ones are dropped once capacity keys are tracked. A dropped key starts
+// over with a fresh limiter.
+type Keyed struct {
+	lock    sync.Mutex
+	cache   *caching.LRUCaching
+	factory func(key interface{}) Limiter
+}
+
+// NewKeyed creates a keyed limiter tracking up to capacity keys, which creates
+// the limiter of a key with factory.
+func NewKeyed(capacity int, factory func(key interface{}) Limiter) *Keyed {
+	return &Keyed{
+		cache:   caching.NewLRUCaching(capacity),
+		factory: factory,
+	}
+}
+
+// Get returns the limiter of key, creating it if needed.
+func (k *Keyed) Get(key interface{}) Limiter {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if l, ok := k.cache.Get(key); ok {
+		return l.(Limiter)
+	}
+	l := k.factory(key)
+	k.cache.Add(key, l)
+	return l
+}
+
+// Allow reports whether an event of key may happen now.
+func (k *Keyed) Allow(key interface{}) bool {
+	return k.Get(key).Allow()
+}
+
+// Reserve reserves a permit for an event of key.
+func (k *Keyed) Reserve(key interface{}) *Reservation {
+	return k.Get(key).Reserve()
+}
+
+// Wait blocks until an event of key may happen or ctx is done.
+func (k *Keyed) Wait(ctx context.Context, key interface{}) error {
+	return k.Get(key).Wait(ctx)
+}
+
+// Len returns the number of keys tracked.
+func (k *Keyed) Len() int {
+	return k.cache.Len()
+}
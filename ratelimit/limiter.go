@@ -0,0 +1,209 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit provides request rate limiters driven by a kmclock.Clock.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
+)
+
+// InfDuration is the delay of a reservation which can't be granted.
+const InfDuration = time.Duration(math.MaxInt64)
+
+var (
+	// ErrExceedsLimit is returned by Wait if more permits are requested than
+	// the limiter can ever grant at once.
+	ErrExceedsLimit = errors.New("request exceeds limiter burst")
+	// ErrExceedsDeadline is returned by Wait if the permits would only become
+	// available after the context deadline.
+	ErrExceedsDeadline = errors.New("rate limit wait would exceed context deadline")
+)
+
+// Limiter controls how frequently events may happen.
+type Limiter interface {
+	// Allow reports whether an event may happen now, consuming a permit if so.
+	Allow() bool
+	// AllowN reports whether n events may happen now.
+	AllowN(n int) bool
+	// Reserve reserves a permit for an event which must wait for the
+	// reservation's delay before happening.
+	Reserve() *Reservation
+	// ReserveN reserves permits for n events.
+	ReserveN(n int) *Reservation
+	// Wait blocks until an event may happen or ctx is done.
+	Wait(ctx context.Context) error
+	// WaitN blocks until n events may happen or ctx is done.
+	WaitN(ctx context.Context, n int) error
+}
+
+// policy is the algorithm of a limiter. Its methods are called with the
+// limiter's lock held.
+type policy interface {
+	// burst returns the maximum number of permits granted at once.
+	burst() int
+	// reserve takes n permits, at most burst, and returns the time at which
+	// they are available. If that is later than now plus maxWait, nothing is
+	// taken and false is returned.
+	reserve(now kmclock.AbsTime, n int, maxWait time.Duration) (kmclock.AbsTime, bool)
+	// cancel gives back n permits reserved for at, which hasn't come yet.
+	cancel(now, at kmclock.AbsTime, n int)
+}
+
+// limiter implements Limiter on top of a policy.
+type limiter struct {
+	lock   sync.Mutex
+	clock  kmclock.Clock
+	policy policy
+}
+
+// Allow reports whether an event may happen now, consuming a permit if so.
+func (l *limiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// AllowN reports whether n events may happen now, consuming n permits if so.
+func (l *limiter) AllowN(n int) bool {
+	return l.reserve(n, 0).ok
+}
+
+// Reserve reserves a permit for an event. The event must wait for the delay
+// of the reservation before happening, or cancel it.
+func (l *limiter) Reserve() *Reservation {
+	return l.ReserveN(1)
+}
+
+// ReserveN reserves permits for n events. The reservation isn't OK if n
+// exceeds the burst of the limiter.
+func (l *limiter) ReserveN(n int) *Reservation {
+	return l.reserve(n, InfDuration)
+}
+
+// Wait blocks until an event may happen. See WaitN.
+func (l *limiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n events may happen. It fails right away if n exceeds
+// the burst of the limiter or the wait would outlast the deadline of ctx. If
+// ctx is done while waiting, the permits are given back and its error is
+// returned. The deadline is related to the wall time of the limiter's clock.
+func (l *limiter) WaitN(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	maxWait := InfDuration
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = deadline.Sub(l.clock.Time())
+	}
+	r := l.reserve(n, maxWait)
+	if !r.ok {
+		if r.exceeds {
+			return ErrExceedsLimit
+		}
+		return ErrExceedsDeadline
+	}
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	timer := l.clock.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+// reserve asks the policy for n permits.
+func (l *limiter) reserve(n int, maxWait time.Duration) *Reservation {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.clock.Now()
+	r := &Reservation{limiter: l, n: n}
+	switch {
+	case n <= 0:
+		r.ok, r.at = true, now
+	case n > l.policy.burst():
+		r.exceeds = true
+	default:
+		r.at, r.ok = l.policy.reserve(now, n, maxWait)
+	}
+	return r
+}
+
+// Reservation holds permits taken from a limiter ahead of time.
+type Reservation struct {
+	limiter  *limiter
+	n        int
+	at       kmclock.AbsTime // time the permits are available
+	ok       bool
+	exceeds  bool // not ok because n exceeds the burst
+	canceled bool
+}
+
+// OK reports whether the permits were granted. A reservation isn't OK if the
+// limiter can never grant that many permits at once.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns how long the event has to wait for the reserved permits, or
+// InfDuration if the reservation isn't OK.
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return InfDuration
+	}
+	if d := r.at.Sub(r.limiter.clock.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Cancel gives the permits back to the limiter, as far as they haven't been
+// used yet, i.e. the delay hasn't elapsed.
+func (r *Reservation) Cancel() {
+	if !r.ok || r.n <= 0 {
+		return
+	}
+	l := r.limiter
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if r.canceled {
+		return
+	}
+	r.canceled = true
+	if now := l.clock.Now(); r.at > now {
+		l.policy.cancel(now, r.at, r.n)
+	}
+}
+
+var (
+	_ Limiter = (*TokenBucket)(nil)
+	_ Limiter = (*GCRA)(nil)
+	_ Limiter = (*SlidingWindowLog)(nil)
+	_ Limiter = (*SlidingWindowCounter)(nil)
+)
@@ -0,0 +1,165 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
+)
+
+// SlidingWindowLog is a limiter admitting at most limit events in any window
+// of the given length. It logs the time of every admitted event, so it is
+// exact but needs memory proportional to the limit.
+type SlidingWindowLog struct {
+	limiter
+	limit  int
+	window time.Duration
+	log    []kmclock.AbsTime // times of the admitted events, ascending
+}
+
+// NewSlidingWindowLog creates a limiter admitting limit events per window. It
+// panics if window isn't positive.
+func NewSlidingWindowLog(clock kmclock.Clock, limit int, window time.Duration) *SlidingWindowLog {
+	if window <= 0 {
+		panic("ratelimit: non-positive window for NewSlidingWindowLog")
+	}
+	l := &SlidingWindowLog{limit: limit, window: window}
+	l.limiter = limiter{clock: clock, policy: l}
+	return l
+}
+
+func (l *SlidingWindowLog) burst() int {
+	return l.limit
+}
+
+func (l *SlidingWindowLog) reserve(now kmclock.AbsTime, n int, maxWait time.Duration) (kmclock.AbsTime, bool) {
+	l.prune(now)
+	at := now
+	if k := len(l.log) + n - l.limit; k > 0 {
+		// Wait until the k oldest events have left the window.
+		at = l.log[k-1].Add(l.window)
+	}
+	if last := len(l.log) - 1; last >= 0 && l.log[last] > at {
+		at = l.log[last]
+	}
+	if at.Sub(now) > maxWait {
+		return 0, false
+	}
+	for i := 0; i < n; i++ {
+		l.log = append(l.log, at)
+	}
+	return at, true
+}
+
+func (l *SlidingWindowLog) cancel(now, at kmclock.AbsTime, n int) {
+	for i := len(l.log) - 1; i >= 0 && n > 0; i-- {
+		if l.log[i] == at {
+			l.log = append(l.log[:i], l.log[i+1:]...)
+			n--
+		}
+	}
+}
+
+// prune drops the events which have left the window.
+func (l *SlidingWindowLog) prune(now kmclock.AbsTime) {
+	i := 0
+	for i < len(l.log) && l.log[i].Add(l.window) <= now {
+		i++
+	}
+	if i > 0 {
+		l.log = append(l.log[:0], l.log[i:]...)
+	}
+}
+
+// SlidingWindowCounter is a limiter approximating a sliding window with two
+// fixed windows. The events of the previous fixed window are weighted by the
+// share of the sliding window still overlapping it, so its memory use is
+// constant, at the price of assuming they were evenly spread.
+type SlidingWindowCounter struct {
+	limiter
+	limit  int
+	window time.Duration
+	origin kmclock.AbsTime
+	counts map[int64]int // admitted events by fixed window index
+}
+
+// NewSlidingWindowCounter creates a limiter admitting about limit events per
+// window. It panics if window isn't positive.
+func NewSlidingWindowCounter(clock kmclock.Clock, limit int, window time.Duration) *SlidingWindowCounter {
+	if window <= 0 {
+		panic("ratelimit: non-positive window for NewSlidingWindowCounter")
+	}
+	c := &SlidingWindowCounter{
+		limit:  limit,
+		window: window,
+		origin: clock.Now(),
+		counts: make(map[int64]int),
+	}
+	c.limiter = limiter{clock: clock, policy: c}
+	return c
+}
+
+func (c *SlidingWindowCounter) burst() int {
+	return c.limit
+}
+
+func (c *SlidingWindowCounter) reserve(now kmclock.AbsTime, n int, maxWait time.Duration) (kmclock.AbsTime, bool) {
+	cur := c.index(now)
+	for j := range c.counts {
+		if j < cur-1 {
+			delete(c.counts, j)
+		}
+	}
+	// Find the first fixed window with room for n events, and the first time
+	// in it at which the weighted previous window leaves enough room.
+	elapsed := now.Sub(c.start(cur))
+	for j := cur; ; j, elapsed = j+1, 0 {
+		if c.counts[j]+n > c.limit {
+			continue
+		}
+		free := float64(c.limit - c.counts[j] - n)
+		offset := elapsed
+		if prev := float64(c.counts[j-1]); prev > free {
+			if e := time.Duration(math.Ceil(float64(c.window) * (1 - free/prev))); e > offset {
+				offset = e
+			}
+		}
+		at := c.start(j).Add(offset)
+		if at.Sub(now) > maxWait {
+			return 0, false
+		}
+		c.counts[j] += n
+		return at, true
+	}
+}
+
+func (c *SlidingWindowCounter) cancel(now, at kmclock.AbsTime, n int) {
+	j := c.index(at)
+	if c.counts[j] -= n; c.counts[j] <= 0 {
+		delete(c.counts, j)
+	}
+}
+
+// index returns the index of the fixed window containing t.
+func (c *SlidingWindowCounter) index(t kmclock.AbsTime) int64 {
+	return int64(t.Sub(c.origin) / c.window)
+}
+
+// start returns the start of the fixed window with index j.
+func (c *SlidingWindowCounter) start(j int64) kmclock.AbsTime {
+	return c.origin.Add(time.Duration(j) * c.window)
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes the delays between attempts.
+type Backoff interface {
+	// Delay returns the wait before retry number attempt, starting at 1,
+	// given the delay before the previous retry, which is 0 for the first.
+	// Do treats a negative delay as 0.
+	Delay(attempt int, prev time.Duration) time.Duration
+}
+
+// ConstantBackoff waits the same interval before every retry.
+type ConstantBackoff struct {
+	Interval time.Duration
+}
+
+// Delay returns the interval.
+func (b ConstantBackoff) Delay(attempt int, prev time.Duration) time.Duration {
+	return b.Interval
+}
+
+// ExponentialBackoff multiplies the delay by a constant factor on every retry.
+type ExponentialBackoff struct {
+	Base       time.Duration // delay before the first retry
+	Max        time.Duration // upper bound of the delay, 0 is unbounded
+	Multiplier float64       // growth factor, defaults to 2
+	Jitter     float64       // fraction of each delay which is randomised, 0 to 1
+}
+
+// Delay returns Base * Multiplier^(attempt-1), capped at Max and with the
+// jitter fraction of it drawn at random.
+func (b ExponentialBackoff) Delay(attempt int, prev time.Duration) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	d := float64(b.Base) * math.Pow(multiplier, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		jitter := math.Min(b.Jitter, 1)
+		d = d*(1-jitter) + d*jitter*rand.Float64()
+	}
+	return clampDuration(d)
+}
+
+// clampDuration converts d to a Duration, saturating instead of overflowing.
+// float64(math.MaxInt64) is 2^63, which doesn't fit, so the comparison must
+// include it. NaN, from a zero Base times an infinite factor, is 0.
+func clampDuration(d float64) time.Duration {
+	if math.IsNaN(d) {
+		return 0
+	}
+	if d >= float64(math.MaxInt64) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// DecorrelatedJitterBackoff draws every delay at random between Base and three
+// times the previous delay, which spreads out competing clients better than
+// jittering a fixed exponential sequence.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration // lower bound of the delay
+	Max  time.Duration // upper bound of the delay, 0 is unbounded
+}
+
+// Delay returns a random delay in [Base, 3*prev), capped at Max.
+func (b DecorrelatedJitterBackoff) Delay(attempt int, prev time.Duration) time.Duration {
+	upper := 3 * prev
+	if prev > math.MaxInt64/3 {
+		upper = math.MaxInt64
+	}
+	d := b.Base
+	if upper > b.Base {
+		d += time.Duration(rand.Int63n(int64(upper - b.Base)))
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// FibonacciBackoff grows the delay along the Fibonacci sequence, slower than
+// doubling: Base, Base, 2*Base, 3*Base, 5*Base and so on.
+type FibonacciBackoff struct {
+	Base time.Duration // delay before the first two retries
+	Max  time.Duration // upper bound of the delay, 0 is unbounded
+}
+
+// Delay returns Base times the Fibonacci number of attempt, capped at Max.
+func (b FibonacciBackoff) Delay(attempt int, prev time.Duration) time.Duration {
+	a, c := time.Duration(0), b.Base
+	for i := 1; i < attempt; i++ {
+		if b.Max > 0 && c >= b.Max || c > math.MaxInt64-a {
+			break
+		}
+		a, c = c, a+c
+	}
+	if b.Max > 0 && c > b.Max {
+		c = b.Max
+	}
+	return c
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retry runs operations again after failures, with configurable
+// backoff, limits and error classification.
+package retry
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	kmclock "github.com/wokaio/fdlib/ext/mclock"
+)
+
+// Policy decides how often and when an operation is retried. Zero fields take
+// their defaults.
+type Policy struct {
+	// Backoff computes the delays, defaults to an ExponentialBackoff from
+	// 100ms to 30s with 20% jitter.
+	Backoff     Backoff
+	MaxAttempts int           // calls of the operation in total, 0 is unbounded
+	MaxElapsed  time.Duration // no retry is started after this, 0 is unbounded
+	// Retryable decides whether an error is worth a retry. If nil, every error
+	// is, except those wrapped by Permanent.
+	Retryable func(err error) bool
+	// OnRetry is called before waiting for a retry with the number of the
+	// failed attempt, its error and the delay.
+	OnRetry func(attempt int, err error, delay time.Duration)
+	Clock   kmclock.Clock // clock for the delays, defaults to kmclock.System
+}
+
+// permanentError marks an error which must not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps err so Do gives up right away and returns err.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// Do calls fn until it succeeds or the policy gives up, and returns the error
+// of the last attempt. A nil policy uses the defaults. If ctx is done while
+// waiting for a retry, its error is returned.
+func Do(ctx context.Context, policy *Policy, fn func(ctx context.Context) error) error {
+	var p Policy
+	if policy != nil {
+		p = *policy
+	}
+	if p.Backoff == nil {
+		p.Backoff = ExponentialBackoff{Base: 100 * time.Millisecond, Max: 30 * time.Second, Jitter: 0.2}
+	}
+	if p.Clock == nil {
+		p.Clock = kmclock.System{}
+	}
+
+	start := p.Clock.Now()
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if p.Retryable != nil && !p.Retryable(err) {
+			return err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return err
+		}
+		delay = p.Backoff.Delay(attempt, delay)
+		if delay < 0 {
+			delay = 0
+		}
+		// Compare without adding, the delay may be as long as time.Duration allows.
+		if p.MaxElapsed > 0 && delay > p.MaxElapsed-p.Clock.Now().Sub(start) {
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, delay)
+		}
+
+		timer := p.Clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -16,7 +16,7 @@ package util
 
 import (
 	"errors"
-	"fmt"
+	"math/big"
 	"regexp"
 	"strconv"
 	"strings"
@@ -28,75 +28,211 @@ const (
 	Megabyte = 1024 * Kilobyte
 	Gigabyte = 1024 * Megabyte
 	Terabyte = 1024 * Gigabyte
+	Petabyte = 1024 * Terabyte
+	Exabyte  = 1024 * Petabyte
 )
 
-var bytesPattern = regexp.MustCompile(`(?i)^(-?\d+(?:\.\d+)?)([KMGT]B?|B)$`)
-var invalidByteQuantityError = errors.New("Byte quantity: M, MB, G, or GB")
+var bytesPattern = regexp.MustCompile(`^(\d+(?:\.\d*)?|\.\d+)\s*([a-zA-Z]*)$`)
+var invalidByteQuantityError = errors.New("Byte quantity must be a non-negative number with a unit like K, KB, KiB, M, MB, MiB, G, GB or GiB")
+var byteQuantityRangeError = errors.New("Byte quantity exceeds 16EiB")
 
-func ByteSize(bytes uint64) string {
-	unit := ""
-	value := float32(bytes)
+// ByteUnits selects the units of a formatted byte size
+type ByteUnits int
 
+const (
+	// UnitsIEC uses the binary units KiB, MiB, GiB, TiB, PiB and EiB
+	UnitsIEC ByteUnits = iota
+	// UnitsSI uses the decimal units kB, MB, GB, TB, PB and EB
+	UnitsSI
+	// UnitsLegacy uses the binary units K, M, G, T, P and E, like ByteSize
+	// was formatted before
+	UnitsLegacy
+)
+
+// unitPrefixes are the prefixes of the units, in increasing order
+const unitPrefixes = "KMGTPE"
+
+// ByteSize is a number of bytes which is formatted and parsed in
+// human-readable units, e.g. in configuration files and flags. It implements
+// fmt.Stringer, encoding.TextMarshaler, encoding.TextUnmarshaler and
+// flag.Value.
+type ByteSize uint64
+
+// String formats the size exactly, so parsing it gives back the same size. It
+// uses the largest IEC or SI unit which needs at most three decimals, e.g.
+// 1.5GiB or 500MB, whichever is shorter, preferring IEC.
+func (b ByteSize) String() string {
+	iec := formatExact(uint64(b), 1024)
+	if si := formatExact(uint64(b), 1000); len(si) < len(iec) {
+		return si
+	}
+	return iec
+}
+
+// formatExact formats bytes in the largest power of base which represents it
+// with at most three decimals
+func formatExact(bytes uint64, base uint64) string {
+	units := make([]uint64, 1, len(unitPrefixes)+1)
+	units[0] = 1
+	for len(units) <= len(unitPrefixes) {
+		units = append(units, units[len(units)-1]*base)
+	}
+	for i := len(unitPrefixes); i > 0; i-- {
+		unit := units[i]
+		if bytes < unit {
+			continue
+		}
+		// The remainder must be whole thousandths of the unit. A power of
+		// 1024 is 2^(10i) and 1000 is 2^3*125, so there it must be a
+		// multiple of unit/8 bytes, which are 125 thousandths.
+		step, thousandths := unit/1000, uint64(1)
+		if base == 1024 {
+			step, thousandths = unit/8, 125
+		}
+		rem := bytes % unit
+		if rem%step != 0 {
+			continue
+		}
+		s := strconv.FormatUint(bytes/unit, 10)
+		if rem != 0 {
+			frac := strconv.FormatUint(1000+rem/step*thousandths, 10)[1:]
+			s += "." + strings.TrimRight(frac, "0")
+		}
+		return s + unitSymbol(i, base == 1000)
+	}
+	return strconv.FormatUint(bytes, 10) + "B"
+}
+
+// unitSymbol returns the symbol of the i-th unit above a byte
+func unitSymbol(i int, si bool) string {
 	switch {
-	case bytes >= Terabyte:
-		unit = "T"
-		value = value / Terabyte
-	case bytes >= Gigabyte:
-		unit = "G"
-		value = value / Gigabyte
-	case bytes >= Megabyte:
-		unit = "M"
-		value = value / Megabyte
-	case bytes >= Kilobyte:
-		unit = "K"
-		value = value / Kilobyte
-	case bytes >= Byte:
-		unit = "B"
-	case bytes == 0:
-		return "0"
+	case si && i == 1:
+		return "kB"
+	case si:
+		return unitPrefixes[i-1:i] + "B"
 	}
+	return unitPrefixes[i-1:i] + "iB"
+}
 
-	stringValue := fmt.Sprintf("%.1f", value)
-	stringValue = strings.TrimSuffix(stringValue, ".0")
-	return fmt.Sprintf("%s%s", stringValue, unit)
+// Format formats the size in the largest unit of the given kind it reaches,
+// with up to precision decimals, or as many as needed if precision is
+// negative. Trailing zeros are dropped. Unlike String, the result may be
+// rounded.
+func (b ByteSize) Format(units ByteUnits, precision int) string {
+	base := 1024.0
+	if units == UnitsSI {
+		base = 1000
+	}
+	value := float64(b)
+	i := 0
+	for i < len(unitPrefixes) && value >= base {
+		value /= base
+		i++
+	}
+	s := strconv.FormatFloat(value, 'f', precision, 64)
+	if rounded, _ := strconv.ParseFloat(s, 64); rounded >= base && i < len(unitPrefixes) {
+		// Rounding reached the next unit, e.g. 1023.99KiB to 1024.0KiB
+		i++
+		s = strconv.FormatFloat(value/base, 'f', precision, 64)
+	}
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+
+	switch {
+	case i == 0 && units == UnitsLegacy:
+		if b == 0 {
+			return "0"
+		}
+		return s + "B"
+	case i == 0:
+		return s + "B"
+	case units == UnitsLegacy:
+		return s + unitPrefixes[i-1:i]
+	}
+	return s + unitSymbol(i, units == UnitsSI)
 }
 
-func ToMegabytes(s string) (uint64, error) {
-	bytes, err := ToBytes(s)
+// MarshalText formats the size like String.
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText parses a size like ParseByteSize.
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	size, err := ParseByteSize(string(text))
 	if err != nil {
-		return 0, err
+		return err
 	}
+	*b = size
+	return nil
+}
 
-	return bytes / Megabyte, nil
+// Set parses a size like ParseByteSize, for use as a flag.Value.
+func (b *ByteSize) Set(s string) error {
+	return b.UnmarshalText([]byte(s))
 }
 
-func ToBytes(s string) (uint64, error) {
+// ParseByteSize parses a size given as a number with an optional fraction and
+// a case-insensitive unit: B or none for bytes, the IEC units KiB to EiB and
+// the single letters K to E for powers of 1024, the SI units KB to EB for
+// powers of 1000. Fractions of a byte are truncated.
+func ParseByteSize(s string) (ByteSize, error) {
+	bytes, err := parseByteQuantity(s, false)
+	return ByteSize(bytes), err
+}
+
+// parseByteQuantity parses a size, with KB to EB as binary units if legacy is
+// set
+func parseByteQuantity(s string, legacy bool) (uint64, error) {
 	parts := bytesPattern.FindStringSubmatch(strings.TrimSpace(s))
-	if len(parts) < 3 {
+	if parts == nil {
 		return 0, invalidByteQuantityError
 	}
 
-	value, err := strconv.ParseFloat(parts[1], 64)
-	if err != nil || value <= 0 {
+	unit := strings.ToUpper(parts[2])
+	multiplier := big.NewInt(1)
+	if unit != "" && unit != "B" {
+		i := strings.IndexByte(unitPrefixes, unit[0])
+		if i < 0 {
+			return 0, invalidByteQuantityError
+		}
+		base := int64(1024)
+		switch unit[1:] {
+		case "", "I", "IB":
+		case "B":
+			if !legacy {
+				base = 1000
+			}
+		default:
+			return 0, invalidByteQuantityError
+		}
+		multiplier.Exp(big.NewInt(base), big.NewInt(int64(i+1)), nil)
+	}
+
+	value, ok := new(big.Rat).SetString(parts[1])
+	if !ok {
 		return 0, invalidByteQuantityError
 	}
+	value.Mul(value, new(big.Rat).SetInt(multiplier))
+	bytes := new(big.Int).Quo(value.Num(), value.Denom())
+	if !bytes.IsUint64() {
+		return 0, byteQuantityRangeError
+	}
+	return bytes.Uint64(), nil
+}
 
-	var bytes uint64
-	unit := strings.ToUpper(parts[2])
-	switch unit[:1] {
-	case "T":
-		bytes = uint64(value * Terabyte)
-	case "G":
-		bytes = uint64(value * Gigabyte)
-	case "M":
-		bytes = uint64(value * Megabyte)
-	case "K":
-		bytes = uint64(value * Kilobyte)
-	case "B":
-		bytes = uint64(value * Byte)
-	default:
-		bytes = uint64(value * Byte)
+func ToMegabytes(s string) (uint64, error) {
+	bytes, err := ToBytes(s)
+	if err != nil {
+		return 0, err
 	}
 
-	return bytes, nil
+	return bytes / Megabyte, nil
+}
+
+// ToBytes parses a size like ParseByteSize, except that KB to EB are binary
+// units for compatibility.
+func ToBytes(s string) (uint64, error) {
+	return parseByteQuantity(s, true)
 }
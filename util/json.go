@@ -15,14 +15,90 @@
 package util
 
 import (
-	"fmt"
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
-// JSONDataStream interface
+// DefaultChunkSize is the amount of output a JSON writer buffers before it is
+// flushed to the underlying io.Writer
+const DefaultChunkSize = 32 * 1024
+
+// maxDepth bounds the nesting of values encoded by reflection, which catches
+// cyclic data structures
+const maxDepth = 1000
+
+// maxPooledBuffer is the largest buffer Marshal returns to its pool
+const maxPooledBuffer = 4 * 1024 * 1024
+
+// UnsupportedTypeError is the error for a value whose type can't be encoded
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "json: unsupported type: " + e.Type.String()
+}
+
+// UnsupportedValueError is the error for a value which can't be encoded, such
+// as a NaN float or a cyclic structure
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "json: unsupported value: " + e.Str
+}
+
+// MarshalerError is the error returned by the MarshalJSON or MarshalText
+// method of a value, or for invalid JSON produced by MarshalJSON
+type MarshalerError struct {
+	Type reflect.Type
+	Err  error
+}
+
+func (e *MarshalerError) Error() string {
+	return "json: error calling marshaler for type " + e.Type.String() + ": " + e.Err.Error()
+}
+
+func (e *MarshalerError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// JSONDataStream writes JSON incrementally, either into an in-memory buffer
+// or, if created by NewJSONWriter, in chunks to an io.Writer.
+//
+// The first error, from encoding a value or writing the output, is kept and
+// stops all further output. It is reported by Err, Flush and PutValue. A
+// stream writing to an io.Writer discards what is still buffered and what is
+// put afterwards, so a dead connection doesn't make it pile up the rest of the
+// document in memory.
 type JSONDataStream struct {
-	buffer []byte
-	comma  bool
+	buffer   []byte
+	comma    bool      // a value precedes the next one at the current level
+	afterKey bool      // a key has been written, so its value needs no separator
+	level    int       // number of open objects and arrays
+	w        io.Writer // destination of the output, nil to keep it in memory
+	chunk    int       // buffered output which triggers a flush to w
+	depth    int       // nesting of the values encoded by reflection
+	opts     JSONOptions
+	objects  []canonicalObject // open objects in canonical mode
+	err      error
 }
 
 // Array JSONDataStream interface
@@ -42,6 +118,12 @@ func (p *JSONDataStream) write(b byte) {
 func (p *JSONDataStream) reset() {
 	p.buffer = p.buffer[:0]
 	p.comma = false
+	p.afterKey = false
+	p.level = 0
+	p.depth = 0
+	p.opts = JSONOptions{}
+	p.objects = p.objects[:0]
+	p.err = nil
 }
 
 // NewJSONDataStream JSON object
@@ -51,138 +133,436 @@ func NewJSONDataStream() *JSONDataStream {
 	return js
 }
 
+// NewJSONWriter returns a stream which writes its output to w whenever more
+// than chunkSize bytes are buffered. Call Flush after the last value to write
+// the rest. A chunkSize of 0 or less means DefaultChunkSize.
+func NewJSONWriter(w io.Writer, chunkSize int) *JSONDataStream {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &JSONDataStream{
+		buffer: make([]byte, 0, chunkSize+chunkSize/4),
+		w:      w,
+		chunk:  chunkSize,
+	}
+}
+
 func (p *JSONDataStream) end() []byte {
 	return p.buffer
 }
 
-func (p *JSONDataStream) consumeComma() {
-	if p.comma {
-		p.write(',')
-		p.comma = false
+// Err returns the first error encountered by the stream
+func (p *JSONDataStream) Err() error {
+	return p.err
+}
+
+// Flush writes the buffered output to the underlying io.Writer. It does
+// nothing for in-memory streams. In canonical mode, the output of an object is
+// held back until the object is closed, because its members are reordered.
+func (p *JSONDataStream) Flush() error {
+	if p.err != nil {
+		p.discard()
+		return p.err
+	}
+	if p.w == nil || len(p.buffer) == 0 || len(p.objects) > 0 {
+		return nil
+	}
+	if _, err := p.w.Write(p.buffer); err != nil {
+		p.setErr(err)
+	}
+	p.buffer = p.buffer[:0]
+	return p.err
+}
+
+// maybeFlush flushes the buffered output once a chunk is full
+func (p *JSONDataStream) maybeFlush() {
+	if p.w != nil && len(p.buffer) >= p.chunk {
+		p.Flush()
 	}
 }
 
-func (p *JSONDataStream) putComma() {
+// setErr records err unless an earlier error is already recorded
+func (p *JSONDataStream) setErr(err error) {
+	if p.err == nil {
+		p.err = err
+		p.discard()
+	}
+}
+
+// discard drops the buffered output of a stream writing to an io.Writer, once
+// an error means it will never be written
+func (p *JSONDataStream) discard() {
+	if p.w != nil {
+		p.buffer = p.buffer[:0]
+	}
+}
+
+// beginValue writes the separator in front of a value: the comma after the
+// previous one and, when indenting, the line break. A value following a key
+// needs neither.
+func (p *JSONDataStream) beginValue() {
+	if p.afterKey {
+		p.afterKey = false
+		return
+	}
 	if p.comma {
 		p.write(',')
 	}
+	if p.level > 0 && p.opts.Indent != "" {
+		p.newline()
+	}
+}
+
+// newline starts a line indented to the current level
+func (p *JSONDataStream) newline() {
+	p.write('\n')
+	p.buffer = append(p.buffer, p.opts.Prefix...)
+	for i := 0; i < p.level; i++ {
+		p.buffer = append(p.buffer, p.opts.Indent...)
+	}
 }
 
 // OpenArray to JSON object
 func (p *JSONDataStream) OpenArray() {
-	p.consumeComma()
+	p.beginValue()
 	p.write('[')
+	p.level++
+	p.comma = false
 }
 
 // OpenObject to JSON object
 func (p *JSONDataStream) OpenObject() {
-	p.consumeComma()
+	p.beginValue()
+	if p.opts.Canonical {
+		p.objects = append(p.objects, canonicalObject{start: len(p.buffer)})
+	}
 	p.write('{')
+	p.level++
+	p.comma = false
 }
 
 // CloseArray to JSON object
 func (p *JSONDataStream) CloseArray() {
-	p.write(']')
-	p.comma = true
+	p.closeContainer(']')
 }
 
 // CloseObject to JSON object
 func (p *JSONDataStream) CloseObject() {
-	p.write('}')
+	if n := len(p.objects); n > 0 {
+		p.sortMembers(&p.objects[n-1])
+		p.objects = p.objects[:n-1]
+	}
+	p.closeContainer('}')
+}
+
+// closeContainer ends the innermost array or object, putting the closing
+// bracket on its own line if the container has elements and output is
+// indented
+func (p *JSONDataStream) closeContainer(c byte) {
+	if p.level > 0 {
+		p.level--
+	}
+	if p.comma && p.opts.Indent != "" {
+		p.newline()
+	}
+	p.write(c)
 	p.comma = true
+	p.afterKey = false
+	p.maybeFlush()
 }
 
 // PutKey to JSON object
 func (p *JSONDataStream) PutKey(key []byte) {
-	p.consumeComma()
+	putKey(p, key)
+}
+
+// putKey writes an object member name and the colon following it
+func putKey[T string | []byte](p *JSONDataStream, key T) {
+	p.beginValue()
+	if n := len(p.objects); n > 0 {
+		o := &p.objects[n-1]
+		o.members = append(o.members, canonicalMember{string(key), len(p.buffer)})
+	}
 	p.write('"')
-	p.escapedCopy(key)
+	p.buffer = appendEscaped(p.buffer, key, p.opts.EscapeHTML)
 	p.write('"')
 	p.write(':')
+	if p.opts.Indent != "" {
+		p.write(' ')
+	}
+	p.comma = false
+	p.afterKey = true
+}
+
+// plain reports whether the output uses the default compact formatting
+func (p *JSONDataStream) plain() bool {
+	return p.opts.Indent == "" && !p.opts.EscapeHTML && !p.opts.Canonical
 }
 
 // PutInt to JSON object
 func (p *JSONDataStream) PutInt(value int) {
-	p.putComma()
+	p.PutInt64(int64(value))
+}
+
+// PutInt64 to JSON object. In canonical mode, integers beyond ±2^53 can't be
+// represented exactly and are reported as UnsupportedValueError.
+func (p *JSONDataStream) PutInt64(value int64) {
+	if p.opts.Canonical && (value > maxExactInt || value < -maxExactInt) {
+		p.setErr(&UnsupportedValueError{reflect.ValueOf(value), "integer " + strconv.FormatInt(value, 10) + " is not exact in canonical JSON"})
+		return
+	}
+	p.beginValue()
 	p.comma = true
-	p.writeArray([]byte(fmt.Sprintf("%d", value)))
+	p.buffer = strconv.AppendInt(p.buffer, value, 10)
+	p.maybeFlush()
 }
 
-// PutFloat64 to JSON object
+// PutUint64 to JSON object, see PutInt64 for canonical mode
+func (p *JSONDataStream) PutUint64(value uint64) {
+	if p.opts.Canonical && value > maxExactInt {
+		p.setErr(&UnsupportedValueError{reflect.ValueOf(value), "integer " + strconv.FormatUint(value, 10) + " is not exact in canonical JSON"})
+		return
+	}
+	p.beginValue()
+	p.comma = true
+	p.buffer = strconv.AppendUint(p.buffer, value, 10)
+	p.maybeFlush()
+}
+
+// PutFloat64 to JSON object, with six decimals unless the stream's options ask
+// for the shortest representation. NaN and infinities are handled according to
+// JSONOptions.NonFinite, by default they are reported as UnsupportedValueError.
 func (p *JSONDataStream) PutFloat64(value float64) {
-	p.putComma()
+	p.putFloat(value, 64)
+}
+
+// PutFloat32 to JSON object, like PutFloat64
+func (p *JSONDataStream) PutFloat32(value float32) {
+	p.putFloat(float64(value), 32)
+}
+
+func (p *JSONDataStream) putFloat(value float64, bits int) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		p.putNonFinite(value, bits)
+		return
+	}
+	p.beginValue()
 	p.comma = true
-	p.writeArray([]byte(fmt.Sprintf("%f", value)))
+	if p.opts.Floats == FloatShortest {
+		p.buffer = appendShortestFloat(p.buffer, value, bits, p.opts.Canonical)
+	} else {
+		p.buffer = strconv.AppendFloat(p.buffer, value, 'f', 6, bits)
+	}
+	p.maybeFlush()
 }
 
 // PutNull to JSON object
 func (p *JSONDataStream) PutNull() {
-	p.putComma()
+	p.beginValue()
 	p.comma = true
 	p.writeArray([]byte("null"))
+	p.maybeFlush()
 }
 
 // PutBoolean to JSON object
 func (p *JSONDataStream) PutBoolean(value bool) {
-	p.putComma()
+	p.beginValue()
 	p.comma = true
 	if value {
 		p.writeArray([]byte("true"))
 	} else {
 		p.writeArray([]byte("false"))
 	}
+	p.maybeFlush()
 }
 
 func (p *JSONDataStream) escapedCopy(value []byte) {
-	for i := 0; i < len(value); i++ {
-		if value[i] != '\\' && (value[i] > '"' || value[i] == ' ') {
-			p.write(value[i])
-		} else if value[i] == '"' {
-			p.write('\\')
-			p.write('"')
-		} else if value[i] == '\\' {
-			p.write('\\')
-			p.write('"')
-		} else if value[i] == '\n' {
-			p.write('\\')
-			p.write('n')
-		} else if value[i] == '\r' {
-			p.write('\\')
-			p.write('r')
-		} else if value[i] == '\t' {
-			p.write('\\')
-			p.write('t')
-		} else if value[i] == '\f' {
-			p.write('\\')
-			p.write('f')
-		} else if value[i] == '\b' {
-			p.write('\\')
-			p.write('b')
-		} else {
-			p.write(value[i])
+	p.buffer = appendEscaped(p.buffer, value, p.opts.EscapeHTML)
+}
+
+// appendEscaped appends s to dst with the characters escaped which JSON
+// strings can't contain. Invalid UTF-8 is replaced by U+FFFD. With html, <, >
+// and & as well as U+2028 and U+2029 are escaped too, so the output can be
+// embedded in HTML script tags.
+func appendEscaped[T string | []byte](dst []byte, s T, html bool) []byte {
+	const hex = "0123456789abcdef"
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			end := i + utf8.UTFMax
+			if end > len(s) {
+				end = len(s)
+			}
+			r, size := utf8.DecodeRune([]byte(s[i:end]))
+			if r == utf8.RuneError && size == 1 {
+				dst = append(dst, s[start:i]...)
+				dst = append(dst, "\ufffd"...)
+				i += size
+				start = i
+				continue
+			}
+			if html && (r == '\u2028' || r == '\u2029') {
+				dst = append(dst, s[start:i]...)
+				dst = append(dst, '\\', 'u', '2', '0', '2', hex[r&0xf])
+				i += size
+				start = i
+				continue
+			}
+			i += size
+			continue
+		}
+		if c >= 0x20 && c != '"' && c != '\\' && (!html || c != '<' && c != '>' && c != '&') {
+			i++
+			continue
 		}
+		dst = append(dst, s[start:i]...)
+		switch c {
+		case '"', '\\':
+			dst = append(dst, '\\', c)
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		case '\t':
+			dst = append(dst, '\\', 't')
+		case '\f':
+			dst = append(dst, '\\', 'f')
+		case '\b':
+			dst = append(dst, '\\', 'b')
+		default:
+			dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		}
+		i++
+		start = i
 	}
+	return append(dst, s[start:]...)
 }
 
 // PutString to JSON object
 func (p *JSONDataStream) PutString(value []byte) {
-	p.putComma()
+	p.beginValue()
 	p.comma = true
 	p.write('"')
 	p.escapedCopy(value)
 	p.write('"')
+	p.maybeFlush()
+}
+
+// putString is PutString for a string, sparing the conversion
+func (p *JSONDataStream) putString(value string) {
+	p.beginValue()
+	p.comma = true
+	p.write('"')
+	p.buffer = appendEscaped(p.buffer, value, p.opts.EscapeHTML)
+	p.write('"')
+	p.maybeFlush()
+}
+
+// PutBytes to JSON object as a base64 string, like encoding/json does
+func (p *JSONDataStream) PutBytes(value []byte) {
+	p.beginValue()
+	p.comma = true
+	p.write('"')
+	n := base64.StdEncoding.EncodedLen(len(value))
+	p.buffer = append(p.buffer, make([]byte, n)...)
+	base64.StdEncoding.Encode(p.buffer[len(p.buffer)-n:], value)
+	p.write('"')
+	p.maybeFlush()
+}
+
+// PutTime to JSON object as an RFC 3339 string with nanoseconds, like
+// encoding/json does
+func (p *JSONDataStream) PutTime(value time.Time) {
+	if y := value.Year(); y < 0 || y >= 10000 {
+		p.setErr(&UnsupportedValueError{reflect.ValueOf(value), "year outside of range [0,9999]"})
+		return
+	}
+	p.beginValue()
+	p.comma = true
+	p.write('"')
+	p.buffer = value.AppendFormat(p.buffer, time.RFC3339Nano)
+	p.write('"')
+	p.maybeFlush()
+}
+
+// putRaw writes JSON produced elsewhere, compacting and validating it. Unless
+// the output is plain, the JSON is re-encoded token by token to format it like
+// the rest.
+func (p *JSONDataStream) putRaw(raw []byte, t reflect.Type) {
+	if !p.plain() {
+		p.putTokens(raw, t)
+		return
+	}
+	p.beginValue()
+	buf := bytes.NewBuffer(p.buffer)
+	if err := json.Compact(buf, raw); err != nil {
+		p.setErr(&MarshalerError{t, err})
+		return
+	}
+	p.buffer = buf.Bytes()
+	p.comma = true
+	p.maybeFlush()
+}
+
+// PutValue writes any value encoding/json could encode. Structs are encoded by
+// their exported fields, honoring json tags like encoding/json does. It
+// returns the first error of the stream.
+func (p *JSONDataStream) PutValue(value interface{}) error {
+	p.routeValueType(value)
+	return p.err
 }
 
 func (p *JSONDataStream) routeValueType(value interface{}) {
+	if p.err != nil {
+		return
+	}
 	switch v := value.(type) {
+	case nil:
+		p.PutNull()
 	case string:
-		p.PutString([]byte(v))
+		p.putString(v)
+	case []byte:
+		if v == nil {
+			p.PutNull()
+		} else {
+			p.PutBytes(v)
+		}
+	case bool:
+		p.PutBoolean(v)
 	case int:
-		p.PutInt(v)
+		p.PutInt64(int64(v))
+	case int8:
+		p.PutInt64(int64(v))
+	case int16:
+		p.PutInt64(int64(v))
+	case int32:
+		p.PutInt64(int64(v))
+	case int64:
+		p.PutInt64(v)
+	case uint:
+		p.PutUint64(uint64(v))
+	case uint8:
+		p.PutUint64(uint64(v))
+	case uint16:
+		p.PutUint64(uint64(v))
+	case uint32:
+		p.PutUint64(uint64(v))
+	case uint64:
+		p.PutUint64(v)
+	case uintptr:
+		p.PutUint64(uint64(v))
+	case float32:
+		p.PutFloat32(v)
 	case float64:
 		p.PutFloat64(v)
-	case bool:
-		p.PutBoolean(v)
+	case json.Number:
+		if v == "" {
+			v = "0"
+		}
+		p.putRaw([]byte(v), reflect.TypeOf(v))
+	case time.Time:
+		p.PutTime(v)
 	case func(array *Array):
 		p.OpenArray()
 		v((*Array)(p))
@@ -191,19 +571,262 @@ func (p *JSONDataStream) routeValueType(value interface{}) {
 		p.OpenObject()
 		v((*Object)(p))
 		p.CloseObject()
+	case []interface{}:
+		if v == nil {
+			p.PutNull()
+			return
+		}
+		if !p.enter(reflect.ValueOf(v)) {
+			return
+		}
+		defer p.leave()
+		p.OpenArray()
+		for _, item := range v {
+			p.routeValueType(item)
+		}
+		p.CloseArray()
+	case map[string]interface{}:
+		if v == nil {
+			p.PutNull()
+			return
+		}
+		if !p.enter(reflect.ValueOf(v)) {
+			return
+		}
+		defer p.leave()
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		p.OpenObject()
+		for _, key := range keys {
+			p.putKeyString(key)
+			p.routeValueType(v[key])
+		}
+		p.CloseObject()
 	default:
+		p.putReflect(reflect.ValueOf(value))
+	}
+}
+
+// enter descends into the elements of v. It reports false if the nesting is
+// too deep, which is most likely due to a cycle.
+func (p *JSONDataStream) enter(v reflect.Value) bool {
+	if p.depth >= maxDepth {
+		p.setErr(&UnsupportedValueError{v, "nesting too deep, possibly a cycle via " + v.Type().String()})
+		return false
+	}
+	p.depth++
+	return true
+}
+
+// leave returns from the elements of a value entered before
+func (p *JSONDataStream) leave() {
+	p.depth--
+}
+
+// putKeyString is PutKey for a string
+func (p *JSONDataStream) putKeyString(key string) {
+	putKey(p, key)
+}
+
+// How a type encodes itself, see marshalerKindOf
+const (
+	marshalsNone = iota // by reflection
+	marshalsSelf        // the type implements a marshaler
+	marshalsAddr        // only the pointer type implements a marshaler
+)
+
+// marshalerKinds caches the marshaler kinds by reflect.Type
+var marshalerKinds sync.Map
+
+// marshalerKindOf returns whether t or *t implements json.Marshaler or
+// encoding.TextMarshaler
+func marshalerKindOf(t reflect.Type) int {
+	if kind, ok := marshalerKinds.Load(t); ok {
+		return kind.(int)
+	}
+	kind := marshalsNone
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		kind = marshalsSelf
+	} else if pt := reflect.PtrTo(t); pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType) {
+		kind = marshalsAddr
+	}
+	marshalerKinds.Store(t, kind)
+	return kind
+}
+
+// putMarshaler writes a value implementing json.Marshaler or
+// encoding.TextMarshaler. It reports false if v implements neither.
+func (p *JSONDataStream) putMarshaler(v reflect.Value) bool {
+	switch marshalerKindOf(v.Type()) {
+	case marshalsNone:
+		return false
+	case marshalsAddr:
+		if !v.CanAddr() {
+			return false
+		}
+		v = v.Addr()
+	}
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		p.PutNull()
+		return true
+	}
+	if m, ok := v.Interface().(json.Marshaler); ok {
+		raw, err := m.MarshalJSON()
+		if err != nil {
+			p.setErr(&MarshalerError{v.Type(), err})
+			return true
+		}
+		p.putRaw(raw, v.Type())
+		return true
+	}
+	text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		p.setErr(&MarshalerError{v.Type(), err})
+		return true
+	}
+	p.PutString(text)
+	return true
+}
+
+// putReflect writes the values the type switch of routeValueType misses
+func (p *JSONDataStream) putReflect(v reflect.Value) {
+	if p.err != nil {
+		return
+	}
+	if !v.IsValid() {
+		p.PutNull()
+		return
+	}
+	if v.Kind() == reflect.Interface && v.IsNil() {
+		p.PutNull()
+		return
+	}
+	if p.putMarshaler(v) {
+		return
+	}
+	if !p.enter(v) {
+		return
+	}
+	defer p.leave()
+
+	switch v.Kind() {
+	case reflect.Bool:
+		p.PutBoolean(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		p.PutInt64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		p.PutUint64(v.Uint())
+	case reflect.Float32:
+		p.putFloat(v.Float(), 32)
+	case reflect.Float64:
+		p.putFloat(v.Float(), 64)
+	case reflect.String:
+		p.putString(v.String())
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			p.PutNull()
+			return
+		}
+		p.putReflect(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			p.PutNull()
+			return
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && !reflect.PtrTo(v.Type().Elem()).Implements(jsonMarshalerType) && !reflect.PtrTo(v.Type().Elem()).Implements(textMarshalerType) {
+			p.PutBytes(v.Bytes())
+			return
+		}
+		p.putList(v)
+	case reflect.Array:
+		p.putList(v)
+	case reflect.Map:
+		p.putMap(v)
+	case reflect.Struct:
+		p.putStruct(v)
+	default:
+		p.setErr(&UnsupportedTypeError{v.Type()})
+	}
+}
+
+// putList writes the elements of a slice or array
+func (p *JSONDataStream) putList(v reflect.Value) {
+	p.OpenArray()
+	for i := 0; i < v.Len() && p.err == nil; i++ {
+		p.putReflect(v.Index(i))
+	}
+	p.CloseArray()
+}
+
+// putMap writes a map with string, integer or encoding.TextMarshaler keys,
+// sorted by key like encoding/json does
+func (p *JSONDataStream) putMap(v reflect.Value) {
+	if v.IsNil() {
 		p.PutNull()
+		return
+	}
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := mapKey(iter.Key())
+		if err != nil {
+			p.setErr(err)
+			return
+		}
+		entries = append(entries, entry{key, iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	p.OpenObject()
+	for _, e := range entries {
+		if p.err != nil {
+			return
+		}
+		p.putKeyString(e.key)
+		p.putReflect(e.value)
 	}
+	p.CloseObject()
 }
 
-// Put to JSON object
+// mapKey converts a map key to its JSON object member name
+func mapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", nil
+		}
+		text, err := m.MarshalText()
+		if err != nil {
+			return "", &MarshalerError{k.Type(), err}
+		}
+		return string(text), nil
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", &UnsupportedTypeError{k.Type()}
+}
+
+// Put to JSON object. Errors are kept by the stream, see Err.
 func (p *Array) Put(value interface{}) {
 	(*JSONDataStream)(p).routeValueType(value)
 }
 
-// Put to JSON object
+// Put to JSON object. Errors are kept by the stream, see Err.
 func (p *Object) Put(key string, value interface{}) {
-	(*JSONDataStream)(p).PutKey([]byte(key))
+	(*JSONDataStream)(p).putKeyString(key)
 	(*JSONDataStream)(p).routeValueType(value)
 }
 
@@ -213,13 +836,32 @@ var streamPool = sync.Pool{
 	},
 }
 
-// Marshal JSON to interface
-func Marshal(value interface{}) []byte {
+// Marshal encodes value to JSON, see PutValue for the supported types. The
+// returned slice is owned by the caller.
+func Marshal(value interface{}) ([]byte, error) {
+	return MarshalWithOptions(value, nil)
+}
+
+// MarshalCanonical encodes value to canonical JSON as defined by RFC 8785,
+// see JSONOptions.Canonical
+func MarshalCanonical(value interface{}) ([]byte, error) {
+	return MarshalWithOptions(value, &JSONOptions{Canonical: true})
+}
+
+// MarshalWithOptions is Marshal with the formatting given by opts
+func MarshalWithOptions(value interface{}, opts *JSONOptions) ([]byte, error) {
 	var js *JSONDataStream = streamPool.Get().(*JSONDataStream)
 	js.reset()
+	js.SetOptions(opts)
 	js.routeValueType(value)
 
-	var ret []byte = js.end()
-	streamPool.Put(js)
-	return ret
+	var ret []byte
+	err := js.err
+	if err == nil {
+		ret = append([]byte(nil), js.end()...)
+	}
+	if cap(js.buffer) <= maxPooledBuffer {
+		streamPool.Put(js)
+	}
+	return ret, err
 }
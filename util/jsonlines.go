@@ -0,0 +1,387 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// DefaultMaxLineSize is the longest line a JSON Lines reader accepts by default
+const DefaultMaxLineSize = 1024 * 1024
+
+// ErrLineTooLong is the error for a JSON Lines record exceeding the maximum
+// line size. It is wrapped in a LineError giving the line number.
+var ErrLineTooLong = errors.New("line too long")
+
+// LineError is the error for an invalid line of JSON Lines input
+type LineError struct {
+	Line int64 // number of the line, starting at 1
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return "json lines: line " + strconv.FormatInt(e.Line, 10) + ": " + e.Err.Error()
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// JSONLinesWriterConfig contains the settings of a JSONLinesWriter
+type JSONLinesWriterConfig struct {
+	BufferSize int // output buffered before it is written, DefaultChunkSize if 0
+
+	// Open returns the destination of a new segment, numbered from 0. It is
+	// needed for rotation, and for the first segment if the writer is
+	// created without one. The previous segment is closed first if it
+	// implements io.Closer.
+	Open func(segment int) (io.Writer, error)
+	// MaxBytes and MaxRecords rotate to a new segment before a record would
+	// make the current one exceed the limit, 0 means no limit. A record
+	// larger than MaxBytes gets a segment of its own.
+	MaxBytes   int64
+	MaxRecords int64
+
+	// Options sets the formatting of the records. Indent is ignored, every
+	// record is a single line.
+	Options *JSONOptions
+}
+
+// JSONLinesWriter writes newline-delimited JSON records, optionally rotating
+// between segments such as log files. It is safe for concurrent use.
+//
+// The first error is kept and stops all further output, like with
+// JSONDataStream.
+type JSONLinesWriter struct {
+	config JSONLinesWriterConfig
+
+	lock    sync.Mutex
+	record  *JSONDataStream // encodes the record being written
+	buffer  []byte
+	w       io.Writer // current segment, nil until opened
+	segment int
+	bytes   int64 // size of the current segment, including buffered output
+	records int64 // number of records in the current segment
+	total   int64
+	err     error
+}
+
+// NewJSONLinesWriter returns a writer whose first segment is w. If w is nil,
+// the first segment is opened by config.Open on the first record.
+func NewJSONLinesWriter(w io.Writer, config *JSONLinesWriterConfig) *JSONLinesWriter {
+	jw := &JSONLinesWriter{w: w, record: NewJSONDataStream()}
+	if config != nil {
+		jw.config = *config
+	}
+	if jw.config.BufferSize <= 0 {
+		jw.config.BufferSize = DefaultChunkSize
+	}
+	if jw.config.Options != nil {
+		opts := *jw.config.Options
+		opts.Indent, opts.Prefix = "", ""
+		jw.config.Options = &opts
+	}
+	jw.buffer = make([]byte, 0, jw.config.BufferSize+jw.config.BufferSize/4)
+	return jw
+}
+
+// Write encodes value as a record, see JSONDataStream.PutValue for the
+// supported types. A record which can't be encoded is dropped and its error
+// returned, without affecting the writer.
+func (jw *JSONLinesWriter) Write(value interface{}) error {
+	return jw.WriteFunc(func(stream *JSONDataStream) {
+		stream.PutValue(value)
+	})
+}
+
+// WriteObject writes an object record whose members are put by fn
+func (jw *JSONLinesWriter) WriteObject(fn func(object *Object)) error {
+	return jw.WriteFunc(func(stream *JSONDataStream) {
+		stream.OpenObject()
+		fn((*Object)(stream))
+		stream.CloseObject()
+	})
+}
+
+// WriteFunc writes a record which fn puts into stream. fn must put exactly
+// one value and must not keep stream.
+func (jw *JSONLinesWriter) WriteFunc(fn func(stream *JSONDataStream)) error {
+	jw.lock.Lock()
+	defer jw.lock.Unlock()
+
+	if jw.err != nil {
+		return jw.err
+	}
+	jw.record.reset()
+	jw.record.SetOptions(jw.config.Options)
+	fn(jw.record)
+	if err := jw.record.Err(); err != nil {
+		return err
+	}
+	line := jw.record.end()
+	if len(line) == 0 {
+		return nil
+	}
+	size := int64(len(line)) + 1
+
+	if jw.w == nil || jw.full(size) {
+		if err := jw.rotate(); err != nil {
+			return err
+		}
+	}
+	jw.buffer = append(jw.buffer, line...)
+	jw.buffer = append(jw.buffer, '\n')
+	jw.bytes += size
+	jw.records++
+	jw.total++
+	if len(jw.buffer) >= jw.config.BufferSize {
+		return jw.flush()
+	}
+	return nil
+}
+
+// full reports whether a record of the given size would exceed the limits of
+// the current segment. It must be called with jw.lock held.
+func (jw *JSONLinesWriter) full(size int64) bool {
+	if jw.config.Open == nil || jw.records == 0 {
+		return false
+	}
+	if jw.config.MaxRecords > 0 && jw.records >= jw.config.MaxRecords {
+		return true
+	}
+	return jw.config.MaxBytes > 0 && jw.bytes+size > jw.config.MaxBytes
+}
+
+// rotate closes the current segment and opens the next one. It must be called
+// with jw.lock held.
+func (jw *JSONLinesWriter) rotate() error {
+	if jw.config.Open == nil {
+		jw.err = errors.New("json lines: no destination to write to")
+		return jw.err
+	}
+	if jw.w != nil {
+		if err := jw.closeSegment(); err != nil {
+			return err
+		}
+		jw.segment++
+	}
+	w, err := jw.config.Open(jw.segment)
+	if err != nil {
+		jw.err = err
+		return err
+	}
+	jw.w = w
+	jw.bytes, jw.records = 0, 0
+	return nil
+}
+
+// closeSegment flushes and closes the current segment. It must be called with
+// jw.lock held.
+func (jw *JSONLinesWriter) closeSegment() error {
+	if err := jw.flush(); err != nil {
+		return err
+	}
+	if c, ok := jw.w.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			jw.err = err
+			return err
+		}
+	}
+	jw.w = nil
+	return nil
+}
+
+// flush writes the buffered records. It must be called with jw.lock held.
+func (jw *JSONLinesWriter) flush() error {
+	if jw.err != nil || len(jw.buffer) == 0 {
+		return jw.err
+	}
+	if _, err := jw.w.Write(jw.buffer); err != nil {
+		jw.err = err
+	}
+	jw.buffer = jw.buffer[:0]
+	return jw.err
+}
+
+// Flush writes the buffered records to the current segment.
+func (jw *JSONLinesWriter) Flush() error {
+	jw.lock.Lock()
+	defer jw.lock.Unlock()
+
+	return jw.flush()
+}
+
+// Close flushes the buffered records and closes the current segment if it
+// implements io.Closer. Further writes fail.
+func (jw *JSONLinesWriter) Close() error {
+	jw.lock.Lock()
+	defer jw.lock.Unlock()
+
+	if jw.w != nil {
+		if err := jw.closeSegment(); err != nil {
+			return err
+		}
+	}
+	if jw.err == nil {
+		jw.err = errors.New("json lines: writer closed")
+	}
+	return nil
+}
+
+// Segment returns the number of the current segment.
+func (jw *JSONLinesWriter) Segment() int {
+	jw.lock.Lock()
+	defer jw.lock.Unlock()
+
+	return jw.segment
+}
+
+// Count returns the number of records written.
+func (jw *JSONLinesWriter) Count() int64 {
+	jw.lock.Lock()
+	defer jw.lock.Unlock()
+
+	return jw.total
+}
+
+// JSONLinesReaderConfig contains the settings of a JSONLinesReader
+type JSONLinesReaderConfig struct {
+	MaxLineSize int // longest accepted line, DefaultMaxLineSize if 0
+
+	// SkipInvalid skips lines which are too long or not a single valid JSON
+	// value. Otherwise they fail the reader with a LineError.
+	SkipInvalid bool
+}
+
+// JSONLinesReader reads newline-delimited JSON records one at a time. Blank
+// lines are ignored. Every record is validated to be a single JSON value.
+type JSONLinesReader struct {
+	config  JSONLinesReaderConfig
+	rd      *bufio.Reader
+	json    *JSONReader // validates the records
+	line    int64
+	skipped int64
+	err     error
+}
+
+// NewJSONLinesReader returns a reader of the records in rd.
+func NewJSONLinesReader(rd io.Reader, config *JSONLinesReaderConfig) *JSONLinesReader {
+	r := &JSONLinesReader{json: NewJSONReader(nil)}
+	if config != nil {
+		r.config = *config
+	}
+	if r.config.MaxLineSize <= 0 {
+		r.config.MaxLineSize = DefaultMaxLineSize
+	}
+	// Room for the line break, so a line of the maximum size fits
+	r.rd = bufio.NewReaderSize(rd, r.config.MaxLineSize+2)
+	return r
+}
+
+// Read returns the next record, which is valid until the following call. At
+// the end of the input, it returns io.EOF. Errors are sticky.
+func (r *JSONLinesReader) Read() ([]byte, error) {
+	for r.err == nil {
+		line, err := r.rd.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			r.line++
+			if err := r.discardLine(); err != nil {
+				r.err = err
+				break
+			}
+			if !r.invalid(ErrLineTooLong) {
+				break
+			}
+			continue
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			r.err = err
+			break
+		}
+		r.line++
+		if len(bytes.TrimRight(line, "\r\n")) > r.config.MaxLineSize {
+			if !r.invalid(ErrLineTooLong) {
+				break
+			}
+			continue
+		}
+		record := bytes.TrimSpace(line)
+		if len(record) == 0 {
+			continue
+		}
+		if verr := r.validate(record); verr != nil {
+			if !r.invalid(verr) {
+				break
+			}
+			continue
+		}
+		return record, nil
+	}
+	return nil, r.err
+}
+
+// discardLine skips the rest of a line which doesn't fit into the buffer
+func (r *JSONLinesReader) discardLine() error {
+	for {
+		_, err := r.rd.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+}
+
+// invalid handles a bad line according to the policy. It reports whether
+// reading goes on.
+func (r *JSONLinesReader) invalid(err error) bool {
+	if r.config.SkipInvalid {
+		r.skipped++
+		return true
+	}
+	r.err = &LineError{r.line, err}
+	return false
+}
+
+// validate checks that record is a single JSON value
+func (r *JSONLinesReader) validate(record []byte) error {
+	r.json.Reset(record)
+	for {
+		kind, err := r.json.NextToken()
+		if err != nil {
+			return err
+		}
+		if kind == TokenEOF {
+			return nil
+		}
+	}
+}
+
+// Line returns the number of the line of the last record or error.
+func (r *JSONLinesReader) Line() int64 {
+	return r.line
+}
+
+// Skipped returns the number of invalid lines skipped.
+func (r *JSONLinesReader) Skipped() int64 {
+	return r.skipped
+}
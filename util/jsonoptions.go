@@ -0,0 +1,258 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// maxExactInt is the largest integer which a float64 represents exactly, the
+// limit for integers in canonical JSON
+const maxExactInt = 1 << 53
+
+// FloatFormat selects how a JSON stream writes floats
+type FloatFormat int
+
+const (
+	// FloatFixed writes floats with six decimals
+	FloatFixed FloatFormat = iota
+	// FloatShortest writes the shortest representation which parses back to
+	// the same float, like encoding/json and ECMAScript do
+	FloatShortest
+)
+
+// NonFinitePolicy selects what a JSON stream does with NaN and infinities,
+// which JSON can't represent
+type NonFinitePolicy int
+
+const (
+	// NonFiniteError fails the stream with an UnsupportedValueError
+	NonFiniteError NonFinitePolicy = iota
+	// NonFiniteNull writes null
+	NonFiniteNull
+	// NonFiniteString writes the strings "NaN", "Infinity" and "-Infinity"
+	NonFiniteString
+)
+
+// JSONOptions contains the formatting settings of a JSON stream. The zero
+// value is the compact output with fixed six decimal floats.
+type JSONOptions struct {
+	// Indent is repeated once per nesting level at the start of every line
+	// of an object or array. If empty, the output is compact.
+	Indent string
+	// Prefix starts every line but the first when indenting.
+	Prefix string
+
+	Floats    FloatFormat
+	NonFinite NonFinitePolicy
+
+	// EscapeHTML escapes <, >, & and the line separators U+2028 and U+2029 in
+	// strings, so the output can be embedded in HTML script tags.
+	EscapeHTML bool
+
+	// Canonical makes the output conform to the JSON Canonicalization Scheme
+	// of RFC 8785, which gives equal data the same bytes, e.g. for signing.
+	// Object members are sorted by their names in UTF-16 code units, floats
+	// are written in their shortest form and strings only have the mandatory
+	// escapes. The other options are ignored, NaN, infinities and integers
+	// beyond ±2^53 are errors.
+	Canonical bool
+}
+
+// canonicalObject is an object being written in canonical mode, whose members
+// are sorted when it is closed
+type canonicalObject struct {
+	start   int // buffer offset of the opening brace
+	members []canonicalMember
+}
+
+// canonicalMember is the name and buffer offset of an object member
+type canonicalMember struct {
+	key   string
+	start int
+}
+
+// SetOptions changes the formatting of the stream. It must be called before
+// the first value is written. A nil opts restores the defaults.
+func (p *JSONDataStream) SetOptions(opts *JSONOptions) {
+	p.opts = JSONOptions{}
+	if opts != nil {
+		p.opts = *opts
+	}
+	if p.opts.Canonical {
+		p.opts = JSONOptions{Floats: FloatShortest, Canonical: true}
+	}
+}
+
+// Options returns the formatting settings of the stream
+func (p *JSONDataStream) Options() JSONOptions {
+	return p.opts
+}
+
+// appendShortestFloat appends the shortest representation of f which parses
+// back to the same float. Like ECMAScript, exponent notation is only used
+// below 1e-6 and from 1e21 on. With canonical, negative zero is written as 0.
+func appendShortestFloat(dst []byte, f float64, bits int, canonical bool) []byte {
+	if f == 0 && canonical {
+		return append(dst, '0')
+	}
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, bits)
+	if format == 'e' {
+		// Shorten a two digit exponent like e-07 to e-7
+		n := len(dst)
+		if n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst
+}
+
+// putNonFinite writes NaN or an infinity according to the NonFinite option
+func (p *JSONDataStream) putNonFinite(value float64, bits int) {
+	switch p.opts.NonFinite {
+	case NonFiniteNull:
+		p.PutNull()
+	case NonFiniteString:
+		switch {
+		case math.IsNaN(value):
+			p.putString("NaN")
+		case value > 0:
+			p.putString("Infinity")
+		default:
+			p.putString("-Infinity")
+		}
+	default:
+		p.setErr(&UnsupportedValueError{reflect.ValueOf(value), strconv.FormatFloat(value, 'g', -1, bits)})
+	}
+}
+
+// putTokens re-encodes the JSON produced by a marshaler of type t through the
+// stream, so it is indented, escaped and sorted like everything else
+func (p *JSONDataStream) putTokens(raw []byte, t reflect.Type) {
+	if !json.Valid(raw) {
+		// Have json.Compact describe the problem, like for plain output
+		p.setErr(&MarshalerError{t, json.Compact(&bytes.Buffer{}, raw)})
+		return
+	}
+	r := NewJSONReader(raw)
+	for p.err == nil {
+		kind, err := r.NextToken()
+		if err != nil {
+			p.setErr(&MarshalerError{t, err})
+			return
+		}
+		switch kind {
+		case TokenEOF:
+			return
+		case TokenObjectStart:
+			p.OpenObject()
+		case TokenObjectEnd:
+			p.CloseObject()
+		case TokenArrayStart:
+			p.OpenArray()
+		case TokenArrayEnd:
+			p.CloseArray()
+		case TokenKey:
+			putKey(p, r.Value())
+		case TokenString:
+			p.PutString(r.Value())
+		case TokenNumber:
+			p.putNumber(r.Value())
+		case TokenTrue:
+			p.PutBoolean(true)
+		case TokenFalse:
+			p.PutBoolean(false)
+		case TokenNull:
+			p.PutNull()
+		}
+	}
+}
+
+// putNumber writes a JSON number literal, which is kept as it is unless the
+// output is canonical
+func (p *JSONDataStream) putNumber(num []byte) {
+	if p.opts.Canonical {
+		f, err := strconv.ParseFloat(string(num), 64)
+		if err != nil {
+			p.setErr(&UnsupportedValueError{reflect.ValueOf(string(num)), "number " + string(num) + " out of range"})
+			return
+		}
+		p.putFloat(f, 64)
+		return
+	}
+	p.beginValue()
+	p.comma = true
+	p.writeArray(num)
+	p.maybeFlush()
+}
+
+// sortMembers orders the members of an object written in canonical mode,
+// which extend from their offsets to the end of the buffer
+func (p *JSONDataStream) sortMembers(o *canonicalObject) {
+	n := len(o.members)
+	if n < 2 || p.err != nil {
+		return
+	}
+	type member struct {
+		key  []uint16
+		data []byte
+	}
+	members := make([]member, n)
+	end := len(p.buffer)
+	for i := n - 1; i >= 0; i-- {
+		start := o.members[i].start
+		members[i] = member{utf16.Encode([]rune(o.members[i].key)), p.buffer[start:end]}
+		end = start - 1 // drop the separating comma
+	}
+	less := func(i, j int) bool { return lessUTF16(members[i].key, members[j].key) }
+	if sort.SliceIsSorted(members, less) {
+		return
+	}
+	sort.SliceStable(members, less)
+
+	first := o.members[0].start
+	sorted := make([]byte, 0, len(p.buffer)-first)
+	for i, m := range members {
+		if i > 0 {
+			sorted = append(sorted, ',')
+		}
+		sorted = append(sorted, m.data...)
+	}
+	copy(p.buffer[first:], sorted)
+}
+
+// lessUTF16 compares strings given as UTF-16 code units
+func lessUTF16(a, b []uint16) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
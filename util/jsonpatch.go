@@ -0,0 +1,485 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// ErrPatchTestFailed is the error of a JSON Patch test operation whose value
+// doesn't match
+var ErrPatchTestFailed = errors.New("test failed")
+
+// PatchOperation is an operation of a JSON Patch as defined by RFC 6902. Op is
+// one of add, remove, replace, move, copy and test.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"` // source of move and copy
+	Value interface{} `json:"value"`          // value of add, replace and test
+}
+
+// JSONPatch is a sequence of operations applied in order
+type JSONPatch []PatchOperation
+
+// PatchError is the error of a JSON Patch operation
+type PatchError struct {
+	Index int // position of the operation in the patch
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *PatchError) Error() string {
+	return "json patch: operation " + strconv.Itoa(e.Index) + " (" + e.Op + " " + strconv.Quote(e.Path) + "): " + e.Err.Error()
+}
+
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON writes only the members the operation uses, so an add of null
+// keeps its value while a remove has none.
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	return MarshalWithOptions(func(object *Object) {
+		object.Put("op", op.Op)
+		object.Put("path", op.Path)
+		switch op.Op {
+		case "move", "copy":
+			object.Put("from", op.From)
+		case "add", "replace", "test":
+			object.Put("value", op.Value)
+		}
+	}, &JSONOptions{Floats: FloatShortest})
+}
+
+// DecodePatch decodes and checks a JSON Patch document.
+func DecodePatch(data []byte) (JSONPatch, error) {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := doc.([]interface{})
+	if !ok {
+		return nil, errors.New("json patch: expected an array of operations")
+	}
+	patch := make(JSONPatch, len(list))
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, &PatchError{Index: i, Err: errors.New("expected an object")}
+		}
+		op := &patch[i]
+		op.Op, _ = m["op"].(string)
+		op.Path, ok = m["path"].(string)
+		if !ok {
+			return nil, &PatchError{Index: i, Op: op.Op, Err: errors.New("missing path")}
+		}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value, ok = m["value"]; !ok {
+				return nil, &PatchError{i, op.Op, op.Path, errors.New("missing value")}
+			}
+		case "move", "copy":
+			if op.From, ok = m["from"].(string); !ok {
+				return nil, &PatchError{i, op.Op, op.Path, errors.New("missing from")}
+			}
+		case "remove":
+		default:
+			return nil, &PatchError{i, op.Op, op.Path, fmt.Errorf("unknown operation %q", op.Op)}
+		}
+	}
+	return patch, nil
+}
+
+// ApplyPatch applies a JSON Patch to a document decoded into
+// map[string]interface{}, []interface{} and scalars. The patch is applied to
+// a copy, which is returned, so doc is left unchanged even if an operation
+// fails. Values of the patch may be of any type Marshal can encode.
+func ApplyPatch(doc interface{}, patch JSONPatch) (interface{}, error) {
+	doc, err := copyJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range patch {
+		if doc, err = applyOperation(doc, op); err != nil {
+			return nil, &PatchError{i, op.Op, op.Path, err}
+		}
+	}
+	return doc, nil
+}
+
+// applyOperation applies op to doc, which it may modify
+func applyOperation(doc interface{}, op PatchOperation) (interface{}, error) {
+	path, err := ParseJSONPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace":
+		value, err := copyJSON(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		return patchSet(doc, path, value, op.Op == "replace")
+	case "remove":
+		if len(path) == 0 {
+			return nil, errors.New("can't remove the whole document")
+		}
+		doc, _, err = patchRemove(doc, path)
+		return doc, err
+	case "move", "copy":
+		from, err := ParseJSONPointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if op.Op == "copy" {
+			if value, err = from.Get(doc); err != nil {
+				return nil, err
+			}
+			if value, err = copyJSON(value); err != nil {
+				return nil, err
+			}
+		} else {
+			if isPointerPrefix(from, path) {
+				if len(from) == len(path) {
+					return doc, nil
+				}
+				return nil, errors.New("can't move a value into itself")
+			}
+			if len(from) == 0 {
+				return nil, errors.New("can't move the whole document")
+			}
+			if doc, value, err = patchRemove(doc, from); err != nil {
+				return nil, err
+			}
+		}
+		return patchSet(doc, path, value, false)
+	case "test":
+		actual, err := path.Get(doc)
+		if err != nil {
+			return nil, err
+		}
+		expected, err := copyJSON(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(actual, expected) {
+			return nil, ErrPatchTestFailed
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// patchSet adds or, with replace, replaces the value at path and returns the
+// updated document
+func patchSet(doc interface{}, path JSONPointer, value interface{}, replace bool) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token := path[0]
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		member, ok := container[token]
+		if len(path) == 1 {
+			if replace && !ok {
+				return nil, fmt.Errorf("%w: no member %q to replace", ErrPathNotFound, token)
+			}
+			container[token] = value
+			return container, nil
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: no member %q", ErrPathNotFound, token)
+		}
+		member, err := patchSet(member, path[1:], value, replace)
+		if err != nil {
+			return nil, err
+		}
+		container[token] = member
+		return container, nil
+	case []interface{}:
+		if len(path) == 1 && !replace {
+			index := len(container)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(container)+1); err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrPathNotFound, err)
+				}
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		}
+		index, err := arrayIndex(token, len(container))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPathNotFound, err)
+		}
+		if len(path) == 1 {
+			container[index] = value
+			return container, nil
+		}
+		if container[index], err = patchSet(container[index], path[1:], value, replace); err != nil {
+			return nil, err
+		}
+		return container, nil
+	}
+	return nil, fmt.Errorf("%w: %s is not a container", ErrPathNotFound, instanceType(doc))
+}
+
+// patchRemove removes the value at path and returns the updated document and
+// the removed value
+func patchRemove(doc interface{}, path JSONPointer) (interface{}, interface{}, error) {
+	token := path[0]
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		member, ok := container[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: no member %q", ErrPathNotFound, token)
+		}
+		if len(path) == 1 {
+			delete(container, token)
+			return container, member, nil
+		}
+		member, removed, err := patchRemove(member, path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		container[token] = member
+		return container, removed, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(container))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrPathNotFound, err)
+		}
+		if len(path) == 1 {
+			removed := container[index]
+			return append(container[:index], container[index+1:]...), removed, nil
+		}
+		var removed interface{}
+		if container[index], removed, err = patchRemove(container[index], path[1:]); err != nil {
+			return nil, nil, err
+		}
+		return container, removed, nil
+	}
+	return nil, nil, fmt.Errorf("%w: %s is not a container", ErrPathNotFound, instanceType(doc))
+}
+
+// isPointerPrefix reports whether prefix equals path or one of its ancestors
+func isPointerPrefix(prefix, path JSONPointer) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffPatch returns a JSON Patch which turns document a into document b.
+// Objects are compared member by member and arrays element by element after
+// skipping their common start and end, so a single insertion or removal
+// yields a single operation.
+func DiffPatch(a, b interface{}) (JSONPatch, error) {
+	a, err := copyJSON(a)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = copyJSON(b); err != nil {
+		return nil, err
+	}
+	var patch JSONPatch
+	diffJSON(&patch, JSONPointer{}, a, b)
+	return patch, nil
+}
+
+// diffJSON appends the operations turning a into b at path
+func diffJSON(patch *JSONPatch, path JSONPointer, a, b interface{}) {
+	if jsonEqual(a, b) {
+		return
+	}
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			diffObjects(patch, path, a, b)
+			return
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok {
+			diffArrays(patch, path, a, b)
+			return
+		}
+	}
+	*patch = append(*patch, PatchOperation{Op: "replace", Path: path.String(), Value: b})
+}
+
+// diffObjects appends the operations turning object a into b, in the order of
+// the member names
+func diffObjects(patch *JSONPatch, path JSONPointer, a, b map[string]interface{}) {
+	for _, name := range sortedKeys(a) {
+		if _, ok := b[name]; !ok {
+			*patch = append(*patch, PatchOperation{Op: "remove", Path: path.Append(name).String()})
+		}
+	}
+	for _, name := range sortedKeys(b) {
+		if old, ok := a[name]; ok {
+			diffJSON(patch, path.Append(name), old, b[name])
+		} else {
+			*patch = append(*patch, PatchOperation{Op: "add", Path: path.Append(name).String(), Value: b[name]})
+		}
+	}
+}
+
+// diffArrays appends the operations turning array a into b
+func diffArrays(patch *JSONPatch, path JSONPointer, a, b []interface{}) {
+	start := 0
+	for start < len(a) && start < len(b) && jsonEqual(a[start], b[start]) {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && jsonEqual(a[endA-1], b[endB-1]) {
+		endA--
+		endB--
+	}
+	i := start
+	for ; i < endA && i < endB; i++ {
+		diffJSON(patch, path.Append(strconv.Itoa(i)), a[i], b[i])
+	}
+	for j := i; j < endA; j++ {
+		*patch = append(*patch, PatchOperation{Op: "remove", Path: path.Append(strconv.Itoa(i)).String()})
+	}
+	for ; i < endB; i++ {
+		*patch = append(*patch, PatchOperation{Op: "add", Path: path.Append(strconv.Itoa(i)).String(), Value: b[i]})
+	}
+}
+
+// MergePatch applies a JSON Merge Patch as defined by RFC 7396 to a copy of
+// doc: members of patch objects replace or, if null, remove the members of
+// the document, recursively. Other patch values replace the document.
+func MergePatch(doc, patch interface{}) (interface{}, error) {
+	doc, err := copyJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	if patch, err = copyJSON(patch); err != nil {
+		return nil, err
+	}
+	return mergeJSON(doc, patch), nil
+}
+
+// mergeJSON merges patch into doc, which it may modify
+func mergeJSON(doc, patch interface{}) interface{} {
+	members, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	target, ok := doc.(map[string]interface{})
+	if !ok {
+		target = make(map[string]interface{}, len(members))
+	}
+	for name, value := range members {
+		if value == nil {
+			delete(target, name)
+		} else {
+			target[name] = mergeJSON(target[name], value)
+		}
+	}
+	return target
+}
+
+// CreateMergePatch returns a JSON Merge Patch which turns document a into
+// document b. Merge patches can't set a member to null, so if b has null
+// members the patch removes them instead.
+func CreateMergePatch(a, b interface{}) (interface{}, error) {
+	a, err := copyJSON(a)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = copyJSON(b); err != nil {
+		return nil, err
+	}
+	return diffMerge(a, b), nil
+}
+
+// diffMerge returns the merge patch turning a into b
+func diffMerge(a, b interface{}) interface{} {
+	from, ok1 := a.(map[string]interface{})
+	to, ok2 := b.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return b
+	}
+	patch := make(map[string]interface{})
+	for name := range from {
+		if _, ok := to[name]; !ok {
+			patch[name] = nil
+		}
+	}
+	for name, value := range to {
+		if old, ok := from[name]; !ok || !jsonEqual(old, value) {
+			patch[name] = diffMerge(old, value)
+		}
+	}
+	return patch
+}
+
+// copyJSON returns a deep copy of a decoded document. Values of other types
+// are converted through their JSON encoding.
+func copyJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, string, json.Number:
+		return v, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			var err error
+			if m[name], err = copyJSON(member); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = copyJSON(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	data, err := MarshalWithOptions(v, &JSONOptions{Floats: FloatShortest})
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(data)
+}
+
+// sortedKeys returns the member names of an object in order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxNumberExponent bounds the exponent of numbers compared exactly, which
+// keeps a tiny literal like 1e999999999 from expanding into a huge integer
+const maxNumberExponent = 10000
+
+// JSONPointer is a JSON Pointer as defined by RFC 6901, the reference tokens
+// leading from the root of a document to one of its values. The empty pointer
+// refers to the whole document.
+type JSONPointer []string
+
+// ParseJSONPointer parses a pointer like "/a/0/b~1c", where ~1 stands for a
+// slash and ~0 for a tilde within a token.
+func ParseJSONPointer(s string) (JSONPointer, error) {
+	if s == "" {
+		return JSONPointer{}, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("json pointer: %q doesn't start with /", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, token := range tokens {
+		if !strings.Contains(token, "~") {
+			continue
+		}
+		var b strings.Builder
+		for j := 0; j < len(token); j++ {
+			if token[j] != '~' {
+				b.WriteByte(token[j])
+				continue
+			}
+			if j+1 == len(token) || token[j+1] != '0' && token[j+1] != '1' {
+				return nil, fmt.Errorf("json pointer: invalid escape in %q", s)
+			}
+			j++
+			if token[j] == '0' {
+				b.WriteByte('~')
+			} else {
+				b.WriteByte('/')
+			}
+		}
+		tokens[i] = b.String()
+	}
+	return tokens, nil
+}
+
+// String returns the pointer in its textual form.
+func (p JSONPointer) String() string {
+	var b strings.Builder
+	for _, token := range p {
+		b.WriteByte('/')
+		for i := 0; i < len(token); i++ {
+			switch token[i] {
+			case '~':
+				b.WriteString("~0")
+			case '/':
+				b.WriteString("~1")
+			default:
+				b.WriteByte(token[i])
+			}
+		}
+	}
+	return b.String()
+}
+
+// Append returns a new pointer extended by tokens, leaving p unchanged.
+func (p JSONPointer) Append(tokens ...string) JSONPointer {
+	q := make(JSONPointer, len(p), len(p)+len(tokens))
+	copy(q, p)
+	return append(q, tokens...)
+}
+
+// Get returns the value p refers to within a document decoded into
+// map[string]interface{}, []interface{} and scalars. It returns an error
+// wrapping ErrPathNotFound if there is no such value.
+func (p JSONPointer) Get(doc interface{}) (interface{}, error) {
+	for i, token := range p {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			member, ok := v[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, p[:i+1])
+			}
+			doc = member
+		case []interface{}:
+			index, err := arrayIndex(token, len(v))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrPathNotFound, p[:i+1], err)
+			}
+			doc = v[index]
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, p[:i+1])
+		}
+	}
+	return doc, nil
+}
+
+// arrayIndex parses a pointer token as an index into an array of length n.
+// RFC 6901 forbids signs and leading zeros.
+func arrayIndex(token string, n int) (int, error) {
+	if token == "" || len(token) > 1 && token[0] == '0' || token[0] < '0' || token[0] > '9' {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if index >= n {
+		return 0, fmt.Errorf("array index %d out of bounds", index)
+	}
+	return index, nil
+}
+
+// decodeJSON decodes a single JSON document into map[string]interface{},
+// []interface{} and scalars, keeping numbers as json.Number
+func decodeJSON(data []byte) (interface{}, error) {
+	return decodeJSONReader(bytes.NewReader(data))
+}
+
+// decodeJSONReader is decodeJSON reading from rd
+func decodeJSONReader(rd io.Reader) (interface{}, error) {
+	dec := json.NewDecoder(rd)
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("json: unexpected data after the document")
+	}
+	return doc, nil
+}
+
+// numberRat converts a JSON number to an exact rational
+func numberRat(n json.Number) (*big.Rat, error) {
+	s := string(n)
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(strings.TrimPrefix(s[i+1:], "+"))
+		if err != nil || exp > maxNumberExponent || exp < -maxNumberExponent {
+			return nil, fmt.Errorf("json: number %s out of range", s)
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("json: invalid number %q", s)
+	}
+	return r, nil
+}
+
+// jsonEqual reports whether two decoded documents are equal, comparing
+// numbers by value so 1, 1.0 and 10e-1 are the same
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			other, ok := b[key]
+			if !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if a == b {
+			return true
+		}
+		x, err1 := numberRat(a)
+		y, err2 := numberRat(b)
+		return err1 == nil && err2 == nil && x.Cmp(y) == 0
+	case string:
+		b, ok := b.(string)
+		return ok && a == b
+	case bool:
+		b, ok := b.(bool)
+		return ok && a == b
+	case nil:
+		return b == nil
+	}
+	return false
+}
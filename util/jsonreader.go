@@ -0,0 +1,774 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ErrPathNotFound is returned by GetPath if the document has no value at the path
+var ErrPathNotFound = errors.New("json: path not found")
+
+// TokenKind is the type of a token read by JSONReader
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota // end of the input
+	TokenObjectStart
+	TokenObjectEnd
+	TokenArrayStart
+	TokenArrayEnd
+	TokenKey // member name of an object, the colon is consumed along with it
+	TokenString
+	TokenNumber
+	TokenTrue
+	TokenFalse
+	TokenNull
+)
+
+var tokenNames = [...]string{"EOF", "'{'", "'}'", "'['", "']'", "key", "string", "number", "true", "false", "null"}
+
+func (k TokenKind) String() string {
+	if k >= 0 && int(k) < len(tokenNames) {
+		return tokenNames[k]
+	}
+	return "TokenKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// SyntaxError describes malformed or unexpected input and where it occurred
+type SyntaxError struct {
+	Msg    string
+	Offset int64 // byte offset in the input
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("json: %s at offset %d", e.Msg, e.Offset)
+}
+
+// reader states, i.e. what the next token may be
+const (
+	readValue      = iota // any value
+	readValueOrEnd        // a value or ']', after '['
+	readKey               // a key, after ',' in an object
+	readKeyOrEnd          // a key or '}', after '{'
+	readCommaOrEnd        // ',' or the end of the enclosing container
+	readDone              // nothing but whitespace, after the top-level value
+)
+
+// JSONReader is a pull tokenizer for a single JSON document, read from a byte
+// slice or streamed from an io.Reader. It doesn't allocate per token: the
+// bytes returned by Value alias the input or an internal buffer and are only
+// valid until the next call.
+//
+// Syntax errors are sticky, all further calls return them. Reading a token of
+// the wrong kind with ReadString, ReadInt64 or ReadFloat64 consumes the token
+// and returns a SyntaxError, but the reader remains usable.
+type JSONReader struct {
+	r       io.Reader // nil when reading from a slice
+	buf     []byte
+	pos     int   // read position in buf
+	base    int64 // input offset of buf[0]
+	tok     int   // start of the current token in buf
+	eof     bool  // r is exhausted
+	err     error
+	state   int
+	stack   []byte // '{' or '[' of the open containers
+	kind    TokenKind
+	value   []byte // content of the current token
+	scratch []byte // buffer for unescaped strings
+}
+
+// NewJSONReader returns a reader over data
+func NewJSONReader(data []byte) *JSONReader {
+	r := new(JSONReader)
+	r.Reset(data)
+	return r
+}
+
+// NewJSONStreamReader returns a reader streaming from rd through a buffer of
+// bufSize bytes, growing it for tokens which don't fit. A bufSize of 0 or
+// less means DefaultChunkSize.
+func NewJSONStreamReader(rd io.Reader, bufSize int) *JSONReader {
+	if bufSize <= 0 {
+		bufSize = DefaultChunkSize
+	}
+	return &JSONReader{r: rd, buf: make([]byte, 0, bufSize)}
+}
+
+// Reset makes the reader start over on data, reusing its buffers
+func (r *JSONReader) Reset(data []byte) {
+	*r = JSONReader{
+		buf:     data,
+		eof:     true,
+		stack:   r.stack[:0],
+		scratch: r.scratch[:0],
+	}
+}
+
+// Value returns the content of the last token: the unescaped text of keys
+// and strings, and the literal text of numbers, true, false and null
+func (r *JSONReader) Value() []byte {
+	return r.value
+}
+
+// Offset returns the input offset at which the last token starts
+func (r *JSONReader) Offset() int64 {
+	return r.base + int64(r.tok)
+}
+
+// Depth returns the number of open objects and arrays
+func (r *JSONReader) Depth() int {
+	return len(r.stack)
+}
+
+// fail records a syntax error at buffer index i
+func (r *JSONReader) fail(i int, format string, args ...interface{}) (TokenKind, error) {
+	r.err = &SyntaxError{Msg: fmt.Sprintf(format, args...), Offset: r.base + int64(i)}
+	return TokenEOF, r.err
+}
+
+// more reads further input into the buffer, keeping the current token. It
+// reports false if there is no more input.
+func (r *JSONReader) more() bool {
+	if r.eof {
+		return false
+	}
+	if r.tok > 0 {
+		// Drop what precedes the current token.
+		n := copy(r.buf, r.buf[r.tok:])
+		r.base += int64(r.tok)
+		r.pos -= r.tok
+		r.buf = r.buf[:n]
+		r.tok = 0
+	}
+	if len(r.buf) == cap(r.buf) {
+		grown := make([]byte, len(r.buf), 2*cap(r.buf)+512)
+		copy(grown, r.buf)
+		r.buf = grown
+	}
+	for i := 0; i < 100; i++ {
+		n, err := r.r.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+n]
+		if err != nil {
+			r.eof = true
+			if err != io.EOF {
+				r.err = err
+			}
+			return n > 0
+		}
+		if n > 0 {
+			return true
+		}
+	}
+	r.eof = true
+	r.err = io.ErrNoProgress
+	return false
+}
+
+// skipSpace skips whitespace and returns the next byte, or false at the end
+// of the input
+func (r *JSONReader) skipSpace() (byte, bool) {
+	for {
+		for r.pos < len(r.buf) {
+			switch c := r.buf[r.pos]; c {
+			case ' ', '\t', '\n', '\r':
+				r.pos++
+			default:
+				return c, true
+			}
+		}
+		r.tok = r.pos
+		if !r.more() {
+			return 0, false
+		}
+	}
+}
+
+// NextToken reads the next token. At the end of the document it returns
+// TokenEOF, or an error if the document is incomplete.
+func (r *JSONReader) NextToken() (TokenKind, error) {
+	if r.err != nil {
+		return TokenEOF, r.err
+	}
+	c, ok := r.skipSpace()
+	if r.err != nil {
+		return TokenEOF, r.err
+	}
+	r.tok, r.value = r.pos, nil
+	if !ok {
+		if r.state == readDone {
+			r.kind = TokenEOF
+			return TokenEOF, nil
+		}
+		return r.fail(r.pos, "unexpected end of input")
+	}
+
+	switch r.state {
+	case readDone:
+		return r.fail(r.pos, "invalid character %q after top-level value", c)
+	case readCommaOrEnd:
+		if c == ']' || c == '}' {
+			return r.closeContainer(c)
+		}
+		if c != ',' {
+			return r.fail(r.pos, "invalid character %q after %s element", c, containerName(r.stack[len(r.stack)-1]))
+		}
+		r.pos++
+		if r.stack[len(r.stack)-1] == '{' {
+			r.state = readKey
+		} else {
+			r.state = readValue
+		}
+		if c, ok = r.skipSpace(); !ok {
+			if r.err != nil {
+				return TokenEOF, r.err
+			}
+			return r.fail(r.pos, "unexpected end of input")
+		}
+		r.tok = r.pos
+	case readKeyOrEnd:
+		if c == '}' {
+			return r.closeContainer(c)
+		}
+		r.state = readKey
+	case readValueOrEnd:
+		if c == ']' {
+			return r.closeContainer(c)
+		}
+		r.state = readValue
+	}
+
+	if r.state == readKey {
+		if c != '"' {
+			return r.fail(r.pos, "invalid character %q looking for object key", c)
+		}
+		if err := r.readString(); err != nil {
+			return TokenEOF, err
+		}
+		if r.r != nil {
+			// Looking for the colon may refill the buffer, keep the key.
+			r.scratch = append(r.scratch[:0], r.value...)
+			r.value = r.scratch
+		}
+		c, ok = r.skipSpace()
+		if !ok || c != ':' {
+			if r.err != nil {
+				return TokenEOF, r.err
+			}
+			if !ok {
+				return r.fail(r.pos, "unexpected end of input")
+			}
+			return r.fail(r.pos, "invalid character %q after object key", c)
+		}
+		r.pos++
+		r.state = readValue
+		r.kind = TokenKey
+		return TokenKey, nil
+	}
+	return r.readValue(c)
+}
+
+// readValue reads a value starting with c
+func (r *JSONReader) readValue(c byte) (TokenKind, error) {
+	switch {
+	case c == '{' || c == '[':
+		r.pos++
+		r.stack = append(r.stack, c)
+		r.value = r.buf[r.tok:r.pos]
+		if c == '{' {
+			r.state, r.kind = readKeyOrEnd, TokenObjectStart
+		} else {
+			r.state, r.kind = readValueOrEnd, TokenArrayStart
+		}
+		return r.kind, nil
+	case c == '"':
+		if err := r.readString(); err != nil {
+			return TokenEOF, err
+		}
+		r.kind = TokenString
+	case c == '-' || c >= '0' && c <= '9':
+		if err := r.readNumber(); err != nil {
+			return TokenEOF, err
+		}
+		r.kind = TokenNumber
+	case c == 't':
+		if err := r.readLiteral("true"); err != nil {
+			return TokenEOF, err
+		}
+		r.kind = TokenTrue
+	case c == 'f':
+		if err := r.readLiteral("false"); err != nil {
+			return TokenEOF, err
+		}
+		r.kind = TokenFalse
+	case c == 'n':
+		if err := r.readLiteral("null"); err != nil {
+			return TokenEOF, err
+		}
+		r.kind = TokenNull
+	default:
+		return r.fail(r.pos, "invalid character %q looking for beginning of value", c)
+	}
+	r.afterValue()
+	return r.kind, nil
+}
+
+// closeContainer reads the closing bracket c
+func (r *JSONReader) closeContainer(c byte) (TokenKind, error) {
+	open := r.stack[len(r.stack)-1]
+	if open == '{' && c != '}' || open == '[' && c != ']' {
+		return r.fail(r.pos, "invalid character %q in %s", c, containerName(open))
+	}
+	r.pos++
+	r.stack = r.stack[:len(r.stack)-1]
+	r.value = r.buf[r.tok:r.pos]
+	if c == '}' {
+		r.kind = TokenObjectEnd
+	} else {
+		r.kind = TokenArrayEnd
+	}
+	r.afterValue()
+	return r.kind, nil
+}
+
+// afterValue sets the state following a complete value
+func (r *JSONReader) afterValue() {
+	if len(r.stack) == 0 {
+		r.state = readDone
+	} else {
+		r.state = readCommaOrEnd
+	}
+}
+
+func containerName(open byte) string {
+	if open == '{' {
+		return "object"
+	}
+	return "array"
+}
+
+// readString reads a string token at r.pos and sets r.value to its unescaped
+// content
+func (r *JSONReader) readString() error {
+	i := r.pos + 1
+	escaped := false
+	for {
+		if i >= len(r.buf) {
+			off := i - r.pos
+			if !r.more() {
+				if r.err != nil {
+					return r.err
+				}
+				_, err := r.fail(len(r.buf), "unexpected end of input in string")
+				return err
+			}
+			i = r.pos + off
+			continue
+		}
+		c := r.buf[i]
+		if c == '"' {
+			break
+		}
+		if c < 0x20 {
+			_, err := r.fail(i, "invalid control character %q in string", c)
+			return err
+		}
+		if c == '\\' {
+			escaped = true
+			if i+1 >= len(r.buf) {
+				off := i - r.pos
+				if !r.more() {
+					if r.err != nil {
+						return r.err
+					}
+					_, err := r.fail(len(r.buf), "unexpected end of input in string")
+					return err
+				}
+				i = r.pos + off
+			}
+			i += 2 // the escape is validated when unescaping
+			continue
+		}
+		i++
+	}
+	content := r.buf[r.pos+1 : i]
+	start := r.pos + 1
+	r.pos = i + 1
+	if !escaped {
+		r.value = content
+		return nil
+	}
+	return r.unescape(content, start)
+}
+
+// unescape decodes the escapes of a string whose content starts at buffer
+// index start
+func (r *JSONReader) unescape(s []byte, start int) error {
+	out := r.scratch[:0]
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c != '\\' {
+			out = append(out, c)
+			i++
+			continue
+		}
+		if i+1 >= len(s) {
+			_, err := r.fail(start+i, "invalid escape in string")
+			return err
+		}
+		switch e := s[i+1]; e {
+		case '"', '\\', '/':
+			out = append(out, e)
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'u':
+			r1, ok := hex4(s[i+2:])
+			if !ok {
+				_, err := r.fail(start+i, "invalid \\u escape in string")
+				return err
+			}
+			i += 6
+			if utf16.IsSurrogate(r1) {
+				if len(s) >= i+6 && s[i] == '\\' && s[i+1] == 'u' {
+					if r2, ok := hex4(s[i+2:]); ok {
+						if dec := utf16.DecodeRune(r1, r2); dec != utf8.RuneError {
+							out = utf8.AppendRune(out, dec)
+							i += 6
+							continue
+						}
+					}
+				}
+				r1 = utf8.RuneError
+			}
+			out = utf8.AppendRune(out, r1)
+			continue
+		default:
+			_, err := r.fail(start+i, "invalid escape %q in string", e)
+			return err
+		}
+		i += 2
+	}
+	r.scratch = out
+	r.value = out
+	return nil
+}
+
+// hex4 decodes the four hex digits at the start of s
+func hex4(s []byte) (rune, bool) {
+	if len(s) < 4 {
+		return 0, false
+	}
+	var v rune
+	for _, c := range s[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c -= 'a' - 10
+		case c >= 'A' && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		v = v<<4 | rune(c)
+	}
+	return v, true
+}
+
+// readNumber reads a number token at r.pos, validating its grammar
+func (r *JSONReader) readNumber() error {
+	i := r.pos
+	for {
+		for i < len(r.buf) && isNumberByte(r.buf[i]) {
+			i++
+		}
+		if i < len(r.buf) {
+			break
+		}
+		off := i - r.pos
+		if !r.more() {
+			if r.err != nil {
+				return r.err
+			}
+			break
+		}
+		i = r.pos + off
+	}
+	num := r.buf[r.pos:i]
+	if n, ok := validNumber(num); !ok {
+		_, err := r.fail(r.pos+n, "invalid number literal %q", num)
+		return err
+	}
+	r.value = num
+	r.pos = i
+	return nil
+}
+
+func isNumberByte(c byte) bool {
+	return c >= '0' && c <= '9' || c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E'
+}
+
+// validNumber reports whether s is a valid JSON number, and otherwise returns
+// the index of the first offending byte
+func validNumber(s []byte) (int, bool) {
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(s) && s[i] == '0':
+		i++
+	case i < len(s) && s[i] >= '1' && s[i] <= '9':
+		i = skipDigits(s, i)
+	default:
+		return i, false
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		if i >= len(s) || s[i] < '0' || s[i] > '9' {
+			return i, false
+		}
+		i = skipDigits(s, i)
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if i >= len(s) || s[i] < '0' || s[i] > '9' {
+			return i, false
+		}
+		i = skipDigits(s, i)
+	}
+	return i, i == len(s)
+}
+
+// skipDigits returns the index of the first non-digit in s from i on
+func skipDigits(s []byte, i int) int {
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+// readLiteral reads the literal lit at r.pos
+func (r *JSONReader) readLiteral(lit string) error {
+	for len(r.buf)-r.pos < len(lit) {
+		if !r.more() {
+			break
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	for i := 0; i < len(lit); i++ {
+		if r.pos+i >= len(r.buf) {
+			_, err := r.fail(r.pos+i, "unexpected end of input in literal %s", lit)
+			return err
+		}
+		if r.buf[r.pos+i] != lit[i] {
+			_, err := r.fail(r.pos+i, "invalid character %q in literal %s", r.buf[r.pos+i], lit)
+			return err
+		}
+	}
+	r.value = r.buf[r.pos : r.pos+len(lit)]
+	r.pos += len(lit)
+	return nil
+}
+
+// Skip skips the next value, including everything nested in it. If the next
+// token is a key, the key and its value are skipped.
+func (r *JSONReader) Skip() error {
+	kind, err := r.NextToken()
+	if err != nil {
+		return err
+	}
+	if kind == TokenKey {
+		if kind, err = r.NextToken(); err != nil {
+			return err
+		}
+	}
+	return r.skipRest(kind)
+}
+
+// skipRest skips the rest of a value whose first token has been read
+func (r *JSONReader) skipRest(kind TokenKind) error {
+	switch kind {
+	case TokenObjectEnd, TokenArrayEnd, TokenEOF:
+		return r.unexpected(kind, "value")
+	case TokenObjectStart, TokenArrayStart:
+	default:
+		return nil
+	}
+	depth := len(r.stack)
+	for len(r.stack) >= depth {
+		if _, err := r.NextToken(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unexpected returns the error for a token of the wrong kind
+func (r *JSONReader) unexpected(kind TokenKind, want string) error {
+	return &SyntaxError{Msg: "expected " + want + ", found " + kind.String(), Offset: r.Offset()}
+}
+
+// ReadString reads a string or key token and returns its content
+func (r *JSONReader) ReadString() (string, error) {
+	kind, err := r.NextToken()
+	if err != nil {
+		return "", err
+	}
+	if kind != TokenString && kind != TokenKey {
+		return "", r.unexpected(kind, "string")
+	}
+	return string(r.value), nil
+}
+
+// ReadInt64 reads a number token which must be an integer within the range
+// of int64
+func (r *JSONReader) ReadInt64() (int64, error) {
+	kind, err := r.NextToken()
+	if err != nil {
+		return 0, err
+	}
+	if kind != TokenNumber {
+		return 0, r.unexpected(kind, "number")
+	}
+	s := r.value
+	neg := s[0] == '-'
+	if neg {
+		s = s[1:]
+	}
+	var n uint64
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, &SyntaxError{Msg: "number " + string(r.value) + " is not an integer", Offset: r.Offset()}
+		}
+		if n > (1<<63)/10 {
+			return 0, &SyntaxError{Msg: "number " + string(r.value) + " overflows int64", Offset: r.Offset()}
+		}
+		n = n*10 + uint64(c-'0')
+	}
+	if neg && n <= 1<<63 {
+		return -int64(n), nil
+	}
+	if !neg && n < 1<<63 {
+		return int64(n), nil
+	}
+	return 0, &SyntaxError{Msg: "number " + string(r.value) + " overflows int64", Offset: r.Offset()}
+}
+
+// ReadFloat64 reads a number token
+func (r *JSONReader) ReadFloat64() (float64, error) {
+	kind, err := r.NextToken()
+	if err != nil {
+		return 0, err
+	}
+	if kind != TokenNumber {
+		return 0, r.unexpected(kind, "number")
+	}
+	f, err := strconv.ParseFloat(string(r.value), 64)
+	if err != nil {
+		return 0, &SyntaxError{Msg: "number " + string(r.value) + " is out of range", Offset: r.Offset()}
+	}
+	return f, nil
+}
+
+// GetPath returns the raw JSON of the value at path within data, where every
+// path element is an object key given as string or an array index given as
+// int. Only the parts of the document before the value are scanned. It
+// returns ErrPathNotFound if there is no such value.
+func GetPath(data []byte, path ...interface{}) ([]byte, error) {
+	var r JSONReader
+	r.Reset(data)
+	for _, elem := range path {
+		kind, err := r.NextToken()
+		if err != nil {
+			return nil, err
+		}
+		switch p := elem.(type) {
+		case string:
+			if kind != TokenObjectStart {
+				if err := r.skipRest(kind); err != nil {
+					return nil, err
+				}
+				return nil, ErrPathNotFound
+			}
+			for {
+				kind, err := r.NextToken()
+				if err != nil {
+					return nil, err
+				}
+				if kind == TokenObjectEnd {
+					return nil, ErrPathNotFound
+				}
+				if string(r.value) == p {
+					break
+				}
+				if err := r.Skip(); err != nil {
+					return nil, err
+				}
+			}
+		case int:
+			if kind != TokenArrayStart || p < 0 {
+				if err := r.skipRest(kind); err != nil {
+					return nil, err
+				}
+				return nil, ErrPathNotFound
+			}
+			for i := 0; i < p; i++ {
+				kind, err := r.NextToken()
+				if err != nil {
+					return nil, err
+				}
+				if kind == TokenArrayEnd {
+					return nil, ErrPathNotFound
+				}
+				if err := r.skipRest(kind); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("json: invalid path element %v of type %T", elem, elem)
+		}
+	}
+	kind, err := r.NextToken()
+	if err != nil {
+		return nil, err
+	}
+	if kind == TokenArrayEnd || kind == TokenObjectEnd {
+		return nil, ErrPathNotFound
+	}
+	start := r.tok
+	if err := r.skipRest(kind); err != nil {
+		return nil, err
+	}
+	return data[start:r.pos], nil
+}
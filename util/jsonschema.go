@@ -0,0 +1,751 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	kutil "github.com/wokaio/fdlib/ext/util"
+)
+
+// schemaType is a bit set of JSON types
+type schemaType uint8
+
+const (
+	schemaNull schemaType = 1 << iota
+	schemaBoolean
+	schemaObject
+	schemaArray
+	schemaNumber
+	schemaInteger
+	schemaString
+)
+
+var schemaTypeNames = map[string]schemaType{
+	"null":    schemaNull,
+	"boolean": schemaBoolean,
+	"object":  schemaObject,
+	"array":   schemaArray,
+	"number":  schemaNumber,
+	"integer": schemaInteger,
+	"string":  schemaString,
+}
+
+// schemaFormats checks the values of the format keyword. Besides common
+// formats, it covers the 0x prefixed hex encodings of kutil.
+var schemaFormats = map[string]func(string) bool{
+	"date-time": func(s string) bool {
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	},
+	"date": func(s string) bool {
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	},
+	"time": func(s string) bool {
+		_, err := time.Parse("15:04:05.999999999Z07:00", s)
+		return err == nil
+	},
+	"email": func(s string) bool {
+		addr, err := mail.ParseAddress(s)
+		return err == nil && addr.Address == s
+	},
+	"ipv4": func(s string) bool {
+		ip := net.ParseIP(s)
+		return ip != nil && ip.To4() != nil && !strings.Contains(s, ":")
+	},
+	"ipv6": func(s string) bool {
+		return net.ParseIP(s) != nil && strings.Contains(s, ":")
+	},
+	"uri": func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && u.IsAbs()
+	},
+	"uuid": regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
+	"hex-bytes": func(s string) bool {
+		_, err := kutil.Decode(s)
+		return err == nil
+	},
+	"hex-uint64": func(s string) bool {
+		_, err := kutil.DecodeUint64(s)
+		return err == nil
+	},
+	"hex-big": func(s string) bool {
+		_, err := kutil.DecodeBig(s)
+		return err == nil
+	},
+}
+
+// SchemaError is a violation of a JSON Schema by a document
+type SchemaError struct {
+	Path    string `json:"path"`    // JSON Pointer to the invalid value in the document
+	Keyword string `json:"keyword"` // JSON Pointer to the violated keyword in the schema
+	Message string `json:"message"`
+}
+
+func (e *SchemaError) Error() string {
+	if e.Path == "" {
+		return "json schema: " + e.Message
+	}
+	return "json schema: " + e.Path + ": " + e.Message
+}
+
+// SchemaErrors is the error returned for a document violating a schema, with
+// all violations found
+type SchemaErrors []*SchemaError
+
+func (e SchemaErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return e[0].Error() + " (and " + strconv.Itoa(len(e)-1) + " more errors)"
+}
+
+// JSONSchema is a compiled JSON Schema. It supports this subset of draft
+// 2020-12, and ignores other keywords:
+//
+//   - type, enum, const
+//   - properties, required, additionalProperties
+//   - items, minItems, maxItems, uniqueItems
+//   - minLength, maxLength, pattern, format
+//   - minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+//   - allOf, anyOf, oneOf, not
+//   - $ref to a JSON Pointer fragment within the document, e.g. "#/$defs/id"
+//
+// Patterns use the RE2 syntax of the regexp package. Known formats are
+// asserted, see schemaFormats, and unknown ones ignored. A JSONSchema is safe
+// for concurrent use.
+type JSONSchema struct {
+	root *schemaNode
+}
+
+// schemaNode is a compiled schema object or boolean
+type schemaNode struct {
+	location string // JSON Pointer of the schema within the document
+	never    bool   // the false schema, which rejects everything
+
+	ref *schemaNode
+
+	types  schemaType
+	enum   []interface{}
+	consts []interface{} // the value of const, if present
+
+	properties map[string]*schemaNode
+	required   []string
+	additional *schemaNode
+
+	items              *schemaNode
+	minItems, maxItems int
+	uniqueItems        bool
+
+	minLength, maxLength int
+	pattern              *regexp.Regexp
+	format               func(string) bool
+	formatName           string
+
+	minimum, maximum                   *big.Rat
+	exclusiveMinimum, exclusiveMaximum *big.Rat
+	multipleOf                         *big.Rat
+
+	allOf, anyOf, oneOf []*schemaNode
+	not                 *schemaNode
+}
+
+// schemaCompiler compiles the schemas of a document, memoized by location so
+// $ref cycles terminate
+type schemaCompiler struct {
+	root  interface{}
+	nodes map[string]*schemaNode
+}
+
+// CompileJSONSchema compiles a JSON Schema document.
+func CompileJSONSchema(data []byte) (*JSONSchema, error) {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	c := &schemaCompiler{root: doc, nodes: make(map[string]*schemaNode)}
+	root, err := c.compile(doc, JSONPointer{})
+	if err != nil {
+		return nil, err
+	}
+	return &JSONSchema{root: root}, nil
+}
+
+// MustCompileJSONSchema is CompileJSONSchema which panics on error, for
+// schemas embedded in the program.
+func MustCompileJSONSchema(data []byte) *JSONSchema {
+	s, err := CompileJSONSchema(data)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// schemaErrorf returns an error about the schema at loc
+func schemaErrorf(loc JSONPointer, format string, args ...interface{}) error {
+	return fmt.Errorf("json schema: invalid schema at %q: %s", loc.String(), fmt.Sprintf(format, args...))
+}
+
+// compile compiles the schema raw found at loc
+func (c *schemaCompiler) compile(raw interface{}, loc JSONPointer) (*schemaNode, error) {
+	if n, ok := c.nodes[loc.String()]; ok {
+		return n, nil
+	}
+	n := &schemaNode{location: loc.String(), minItems: -1, maxItems: -1, minLength: -1, maxLength: -1}
+	c.nodes[n.location] = n
+
+	var s map[string]interface{}
+	switch raw := raw.(type) {
+	case bool:
+		n.never = !raw
+		return n, nil
+	case map[string]interface{}:
+		s = raw
+	default:
+		return nil, schemaErrorf(loc, "expected an object or boolean")
+	}
+
+	var err error
+	if ref, ok := s["$ref"]; ok {
+		if n.ref, err = c.compileRef(ref, loc.Append("$ref")); err != nil {
+			return nil, err
+		}
+	}
+	if defs, ok := s["$defs"].(map[string]interface{}); ok {
+		for name, def := range defs {
+			if _, err := c.compile(def, loc.Append("$defs", name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if t, ok := s["type"]; ok {
+		if n.types, err = parseSchemaTypes(t, loc.Append("type")); err != nil {
+			return nil, err
+		}
+	}
+	if enum, ok := s["enum"]; ok {
+		if n.enum, ok = enum.([]interface{}); !ok {
+			return nil, schemaErrorf(loc.Append("enum"), "expected an array")
+		}
+	}
+	if value, ok := s["const"]; ok {
+		n.consts = []interface{}{value}
+	}
+
+	if props, ok := s["properties"]; ok {
+		m, ok := props.(map[string]interface{})
+		if !ok {
+			return nil, schemaErrorf(loc.Append("properties"), "expected an object")
+		}
+		n.properties = make(map[string]*schemaNode, len(m))
+		for name, prop := range m {
+			if n.properties[name], err = c.compile(prop, loc.Append("properties", name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if required, ok := s["required"]; ok {
+		list, ok := required.([]interface{})
+		if !ok {
+			return nil, schemaErrorf(loc.Append("required"), "expected an array of strings")
+		}
+		for _, name := range list {
+			name, ok := name.(string)
+			if !ok {
+				return nil, schemaErrorf(loc.Append("required"), "expected an array of strings")
+			}
+			n.required = append(n.required, name)
+		}
+	}
+	if n.additional, err = c.compileOptional(s, "additionalProperties", loc); err != nil {
+		return nil, err
+	}
+
+	if n.items, err = c.compileOptional(s, "items", loc); err != nil {
+		return nil, err
+	}
+	if n.minItems, err = parseSchemaCount(s, "minItems", loc); err != nil {
+		return nil, err
+	}
+	if n.maxItems, err = parseSchemaCount(s, "maxItems", loc); err != nil {
+		return nil, err
+	}
+	if unique, ok := s["uniqueItems"]; ok {
+		if n.uniqueItems, ok = unique.(bool); !ok {
+			return nil, schemaErrorf(loc.Append("uniqueItems"), "expected a boolean")
+		}
+	}
+
+	if n.minLength, err = parseSchemaCount(s, "minLength", loc); err != nil {
+		return nil, err
+	}
+	if n.maxLength, err = parseSchemaCount(s, "maxLength", loc); err != nil {
+		return nil, err
+	}
+	if pattern, ok := s["pattern"]; ok {
+		expr, ok := pattern.(string)
+		if !ok {
+			return nil, schemaErrorf(loc.Append("pattern"), "expected a string")
+		}
+		if n.pattern, err = regexp.Compile(expr); err != nil {
+			return nil, schemaErrorf(loc.Append("pattern"), "%v", err)
+		}
+	}
+	if format, ok := s["format"]; ok {
+		if n.formatName, ok = format.(string); !ok {
+			return nil, schemaErrorf(loc.Append("format"), "expected a string")
+		}
+		n.format = schemaFormats[n.formatName]
+	}
+
+	for _, kw := range []struct {
+		name string
+		dst  **big.Rat
+	}{
+		{"minimum", &n.minimum},
+		{"maximum", &n.maximum},
+		{"exclusiveMinimum", &n.exclusiveMinimum},
+		{"exclusiveMaximum", &n.exclusiveMaximum},
+		{"multipleOf", &n.multipleOf},
+	} {
+		if *kw.dst, err = parseSchemaNumber(s, kw.name, loc); err != nil {
+			return nil, err
+		}
+	}
+	if n.multipleOf != nil && n.multipleOf.Sign() <= 0 {
+		return nil, schemaErrorf(loc.Append("multipleOf"), "expected a positive number")
+	}
+
+	for _, kw := range []struct {
+		name string
+		dst  *[]*schemaNode
+	}{
+		{"allOf", &n.allOf},
+		{"anyOf", &n.anyOf},
+		{"oneOf", &n.oneOf},
+	} {
+		raw, ok := s[kw.name]
+		if !ok {
+			continue
+		}
+		list, ok := raw.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, schemaErrorf(loc.Append(kw.name), "expected a non-empty array")
+		}
+		for i, sub := range list {
+			node, err := c.compile(sub, loc.Append(kw.name, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			*kw.dst = append(*kw.dst, node)
+		}
+	}
+	if n.not, err = c.compileOptional(s, "not", loc); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// compileOptional compiles the subschema of a keyword, nil if it is absent
+func (c *schemaCompiler) compileOptional(s map[string]interface{}, keyword string, loc JSONPointer) (*schemaNode, error) {
+	raw, ok := s[keyword]
+	if !ok {
+		return nil, nil
+	}
+	return c.compile(raw, loc.Append(keyword))
+}
+
+// compileRef resolves a $ref within the document
+func (c *schemaCompiler) compileRef(ref interface{}, loc JSONPointer) (*schemaNode, error) {
+	s, ok := ref.(string)
+	if !ok || !strings.HasPrefix(s, "#") {
+		return nil, schemaErrorf(loc, "only references within the document like \"#/$defs/name\" are supported")
+	}
+	fragment, err := url.PathUnescape(s[1:])
+	if err != nil {
+		return nil, schemaErrorf(loc, "%v", err)
+	}
+	target, err := ParseJSONPointer(fragment)
+	if err != nil {
+		return nil, schemaErrorf(loc, "%v", err)
+	}
+	raw, err := target.Get(c.root)
+	if err != nil {
+		return nil, schemaErrorf(loc, "unresolvable reference %q", s)
+	}
+	return c.compile(raw, target)
+}
+
+// parseSchemaTypes parses the value of the type keyword
+func parseSchemaTypes(raw interface{}, loc JSONPointer) (schemaType, error) {
+	names, ok := raw.([]interface{})
+	if !ok {
+		names = []interface{}{raw}
+	}
+	var types schemaType
+	for _, name := range names {
+		name, _ := name.(string)
+		t, ok := schemaTypeNames[name]
+		if !ok {
+			return 0, schemaErrorf(loc, "unknown type %q", name)
+		}
+		types |= t
+	}
+	return types, nil
+}
+
+// parseSchemaCount parses a keyword with a non-negative integer value, -1 if
+// it is absent
+func parseSchemaCount(s map[string]interface{}, keyword string, loc JSONPointer) (int, error) {
+	r, err := parseSchemaNumber(s, keyword, loc)
+	if err != nil || r == nil {
+		return -1, err
+	}
+	if !r.IsInt() || r.Sign() < 0 || !r.Num().IsInt64() || r.Num().Int64() > int64(^uint(0)>>1) {
+		return -1, schemaErrorf(loc.Append(keyword), "expected a non-negative integer")
+	}
+	return int(r.Num().Int64()), nil
+}
+
+// parseSchemaNumber parses a keyword with a numeric value, nil if it is absent
+func parseSchemaNumber(s map[string]interface{}, keyword string, loc JSONPointer) (*big.Rat, error) {
+	raw, ok := s[keyword]
+	if !ok {
+		return nil, nil
+	}
+	n, ok := raw.(json.Number)
+	if !ok {
+		return nil, schemaErrorf(loc.Append(keyword), "expected a number")
+	}
+	r, err := numberRat(n)
+	if err != nil {
+		return nil, schemaErrorf(loc.Append(keyword), "%v", err)
+	}
+	return r, nil
+}
+
+// Validate validates a JSON document. It returns SchemaErrors if the document
+// violates the schema, or the error of decoding it.
+func (s *JSONSchema) Validate(data []byte) error {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	return s.ValidateDocument(doc)
+}
+
+// ValidateReader validates the JSON document read from rd, such as the body of
+// an HTTP request. Wrap the body in http.MaxBytesReader to bound its size.
+func (s *JSONSchema) ValidateReader(rd io.Reader) error {
+	doc, err := decodeJSONReader(rd)
+	if err != nil {
+		return err
+	}
+	return s.ValidateDocument(doc)
+}
+
+// ValidateValue validates any value Marshal can encode, by its JSON encoding.
+func (s *JSONSchema) ValidateValue(value interface{}) error {
+	data, err := MarshalWithOptions(value, &JSONOptions{Floats: FloatShortest})
+	if err != nil {
+		return err
+	}
+	return s.Validate(data)
+}
+
+// ValidateDocument validates a document decoded into map[string]interface{},
+// []interface{} and scalars, with numbers as json.Number like a json.Decoder
+// with UseNumber produces.
+func (s *JSONSchema) ValidateDocument(doc interface{}) error {
+	if errs := s.root.validate(doc, JSONPointer{}, 0); len(errs) > 0 {
+		return SchemaErrors(errs)
+	}
+	return nil
+}
+
+// fail returns a violation of a keyword of n by the value at path
+func (n *schemaNode) fail(path JSONPointer, keyword, format string, args ...interface{}) *SchemaError {
+	return &SchemaError{
+		Path:    path.String(),
+		Keyword: n.location + "/" + keyword,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// valid reports whether v satisfies n
+func (n *schemaNode) valid(v interface{}, path JSONPointer, depth int) bool {
+	return len(n.validate(v, path, depth)) == 0
+}
+
+// validate returns the violations of n by the value v at path
+func (n *schemaNode) validate(v interface{}, path JSONPointer, depth int) []*SchemaError {
+	if n.never {
+		return []*SchemaError{{Path: path.String(), Keyword: n.location, Message: "no value is allowed"}}
+	}
+	if depth >= maxDepth {
+		return []*SchemaError{{Path: path.String(), Keyword: n.location, Message: "schema nesting too deep, possibly a $ref cycle"}}
+	}
+	var errs []*SchemaError
+	if n.ref != nil {
+		errs = append(errs, n.ref.validate(v, path, depth+1)...)
+	}
+
+	if n.types != 0 && !n.types.matches(v) {
+		errs = append(errs, n.fail(path, "type", "expected %s, found %s", n.types, instanceType(v)))
+	}
+	if n.enum != nil && !containsJSON(n.enum, v) {
+		errs = append(errs, n.fail(path, "enum", "value is not one of the allowed values"))
+	}
+	if n.consts != nil && !jsonEqual(n.consts[0], v) {
+		errs = append(errs, n.fail(path, "const", "value is not the allowed value"))
+	}
+
+	switch v := v.(type) {
+	case string:
+		errs = n.validateString(v, path, errs)
+	case json.Number:
+		errs = n.validateNumber(v, path, errs)
+	case []interface{}:
+		errs = n.validateArray(v, path, depth, errs)
+	case map[string]interface{}:
+		errs = n.validateObject(v, path, depth, errs)
+	}
+
+	for _, sub := range n.allOf {
+		errs = append(errs, sub.validate(v, path, depth+1)...)
+	}
+	if n.anyOf != nil {
+		matched := false
+		for _, sub := range n.anyOf {
+			if sub.valid(v, path, depth+1) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, n.fail(path, "anyOf", "value matches none of the schemas"))
+		}
+	}
+	if n.oneOf != nil {
+		matches := 0
+		for _, sub := range n.oneOf {
+			if sub.valid(v, path, depth+1) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			errs = append(errs, n.fail(path, "oneOf", "value matches %d of the schemas, expected exactly one", matches))
+		}
+	}
+	if n.not != nil && n.not.valid(v, path, depth+1) {
+		errs = append(errs, n.fail(path, "not", "value matches a disallowed schema"))
+	}
+	return errs
+}
+
+// validateString checks the string keywords
+func (n *schemaNode) validateString(v string, path JSONPointer, errs []*SchemaError) []*SchemaError {
+	if n.minLength >= 0 || n.maxLength >= 0 {
+		length := utf8.RuneCountInString(v)
+		if n.minLength >= 0 && length < n.minLength {
+			errs = append(errs, n.fail(path, "minLength", "string shorter than %d characters", n.minLength))
+		}
+		if n.maxLength >= 0 && length > n.maxLength {
+			errs = append(errs, n.fail(path, "maxLength", "string longer than %d characters", n.maxLength))
+		}
+	}
+	if n.pattern != nil && !n.pattern.MatchString(v) {
+		errs = append(errs, n.fail(path, "pattern", "string doesn't match the pattern %q", n.pattern.String()))
+	}
+	if n.format != nil && !n.format(v) {
+		errs = append(errs, n.fail(path, "format", "string is not a valid %s", n.formatName))
+	}
+	return errs
+}
+
+// validateNumber checks the numeric keywords
+func (n *schemaNode) validateNumber(v json.Number, path JSONPointer, errs []*SchemaError) []*SchemaError {
+	if n.minimum == nil && n.maximum == nil && n.exclusiveMinimum == nil && n.exclusiveMaximum == nil && n.multipleOf == nil {
+		return errs
+	}
+	r, err := numberRat(v)
+	if err != nil {
+		return append(errs, &SchemaError{Path: path.String(), Keyword: n.location, Message: err.Error()})
+	}
+	if n.minimum != nil && r.Cmp(n.minimum) < 0 {
+		errs = append(errs, n.fail(path, "minimum", "number less than %s", ratString(n.minimum)))
+	}
+	if n.maximum != nil && r.Cmp(n.maximum) > 0 {
+		errs = append(errs, n.fail(path, "maximum", "number greater than %s", ratString(n.maximum)))
+	}
+	if n.exclusiveMinimum != nil && r.Cmp(n.exclusiveMinimum) <= 0 {
+		errs = append(errs, n.fail(path, "exclusiveMinimum", "number not greater than %s", ratString(n.exclusiveMinimum)))
+	}
+	if n.exclusiveMaximum != nil && r.Cmp(n.exclusiveMaximum) >= 0 {
+		errs = append(errs, n.fail(path, "exclusiveMaximum", "number not less than %s", ratString(n.exclusiveMaximum)))
+	}
+	if n.multipleOf != nil && !new(big.Rat).Quo(r, n.multipleOf).IsInt() {
+		errs = append(errs, n.fail(path, "multipleOf", "number not a multiple of %s", ratString(n.multipleOf)))
+	}
+	return errs
+}
+
+// validateArray checks the array keywords
+func (n *schemaNode) validateArray(v []interface{}, path JSONPointer, depth int, errs []*SchemaError) []*SchemaError {
+	if n.minItems >= 0 && len(v) < n.minItems {
+		errs = append(errs, n.fail(path, "minItems", "array has fewer than %d items", n.minItems))
+	}
+	if n.maxItems >= 0 && len(v) > n.maxItems {
+		errs = append(errs, n.fail(path, "maxItems", "array has more than %d items", n.maxItems))
+	}
+	if n.uniqueItems {
+	unique:
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if jsonEqual(v[i], v[j]) {
+					errs = append(errs, n.fail(path, "uniqueItems", "items %d and %d are equal", i, j))
+					break unique
+				}
+			}
+		}
+	}
+	if n.items != nil {
+		for i, item := range v {
+			errs = append(errs, n.items.validate(item, path.Append(strconv.Itoa(i)), depth+1)...)
+		}
+	}
+	return errs
+}
+
+// validateObject checks the object keywords. Members are visited in sorted
+// order to report violations deterministically.
+func (n *schemaNode) validateObject(v map[string]interface{}, path JSONPointer, depth int, errs []*SchemaError) []*SchemaError {
+	for _, name := range n.required {
+		if _, ok := v[name]; !ok {
+			errs = append(errs, n.fail(path, "required", "missing property %q", name))
+		}
+	}
+	if n.properties == nil && n.additional == nil {
+		return errs
+	}
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if prop, ok := n.properties[name]; ok {
+			errs = append(errs, prop.validate(v[name], path.Append(name), depth+1)...)
+		} else if n.additional != nil {
+			if n.additional.never {
+				errs = append(errs, n.fail(path.Append(name), "additionalProperties", "property is not allowed"))
+			} else {
+				errs = append(errs, n.additional.validate(v[name], path.Append(name), depth+1)...)
+			}
+		}
+	}
+	return errs
+}
+
+// matches reports whether v is of one of the types
+func (t schemaType) matches(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return t&schemaNull != 0
+	case bool:
+		return t&schemaBoolean != 0
+	case map[string]interface{}:
+		return t&schemaObject != 0
+	case []interface{}:
+		return t&schemaArray != 0
+	case string:
+		return t&schemaString != 0
+	case json.Number:
+		if t&schemaNumber != 0 {
+			return true
+		}
+		if t&schemaInteger != 0 {
+			r, err := numberRat(v)
+			return err == nil && r.IsInt()
+		}
+	}
+	return false
+}
+
+// String lists the names of the types
+func (t schemaType) String() string {
+	var names []string
+	for _, name := range []string{"null", "boolean", "object", "array", "number", "integer", "string"} {
+		if t&schemaTypeNames[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+// instanceType returns the JSON type name of a decoded value
+func instanceType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		if r, err := numberRat(v); err == nil && r.IsInt() {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// ratString formats a number of the schema for messages
+func ratString(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	f, _ := r.Float64()
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// containsJSON reports whether list has a value equal to v
+func containsJSON(list []interface{}, v interface{}) bool {
+	for _, item := range list {
+		if jsonEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
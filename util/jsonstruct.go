@@ -0,0 +1,298 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// structField is a struct field as encoded into a JSON object
+type structField struct {
+	name      string
+	key       []byte // member name, escaped and quoted, followed by a colon
+	index     []int  // path of field indices, through embedded structs
+	tagged    bool   // the name comes from a json tag
+	omitEmpty bool
+	quoted    bool // the value is encoded as a JSON string, by the string option
+}
+
+// structPlan lists the fields of a struct type in encoding order
+type structPlan struct {
+	fields []structField
+}
+
+// structPlans caches the plans by reflect.Type
+var structPlans sync.Map
+
+// cachedStructPlan returns the plan of struct type t, building it on first use
+func cachedStructPlan(t reflect.Type) *structPlan {
+	if plan, ok := structPlans.Load(t); ok {
+		return plan.(*structPlan)
+	}
+	plan, _ := structPlans.LoadOrStore(t, buildStructPlan(t))
+	return plan.(*structPlan)
+}
+
+// buildStructPlan collects the fields of t following the rules of
+// encoding/json: exported fields are encoded under their name or json tag,
+// fields of embedded structs are promoted, and of several fields with the same
+// name the shallowest one wins, or the tagged one among equally deep fields.
+// Ambiguous names are dropped, including the fields of a struct type embedded
+// twice at the same depth.
+func buildStructPlan(t reflect.Type) *structPlan {
+	type level struct {
+		typ   reflect.Type
+		index []int
+	}
+	var (
+		fields  []structField
+		visited = make(map[reflect.Type]bool)
+		current = []level{{typ: t}}
+	)
+	for len(current) > 0 {
+		var next []level
+		// A struct type embedded more than once at this depth makes its
+		// fields ambiguous, record them twice so neither copy dominates.
+		count := make(map[reflect.Type]int)
+		for _, l := range current {
+			count[l.typ]++
+		}
+		for _, l := range current {
+			if visited[l.typ] {
+				continue
+			}
+			visited[l.typ] = true
+
+			for i := 0; i < l.typ.NumField(); i++ {
+				sf := l.typ.Field(i)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous {
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts := parseJSONTag(tag)
+				index := append(append([]int(nil), l.index...), i)
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, level{ft, index})
+					continue
+				}
+
+				f := structField{
+					name:      name,
+					index:     index,
+					tagged:    name != "",
+					omitEmpty: opts.has("omitempty"),
+				}
+				if f.name == "" {
+					f.name = sf.Name
+				}
+				if opts.has("string") && !implementsMarshaler(ft) {
+					switch ft.Kind() {
+					case reflect.Bool, reflect.String,
+						reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+						reflect.Float32, reflect.Float64:
+						f.quoted = true
+					}
+				}
+				f.key = append(appendEscaped([]byte{'"'}, f.name, false), '"', ':')
+				fields = append(fields, f)
+				if count[l.typ] > 1 {
+					fields = append(fields, f)
+				}
+			}
+		}
+		current = next
+	}
+
+	// Resolve the names occurring more than once.
+	byName := make(map[string][]int)
+	for i, f := range fields {
+		byName[f.name] = append(byName[f.name], i)
+	}
+	plan := new(structPlan)
+	for _, f := range fields {
+		candidates := byName[f.name]
+		if len(candidates) > 1 && !dominantField(fields, candidates, f) {
+			continue
+		}
+		plan.fields = append(plan.fields, f)
+	}
+	sort.SliceStable(plan.fields, func(i, j int) bool {
+		a, b := plan.fields[i].index, plan.fields[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return plan
+}
+
+// dominantField reports whether f wins over the other fields with its name:
+// it must be the only shallowest one, or the only tagged one of those.
+func dominantField(fields []structField, candidates []int, f structField) bool {
+	shallowest := len(f.index)
+	for _, i := range candidates {
+		if d := len(fields[i].index); d < shallowest {
+			return false
+		}
+	}
+	var peers, tagged int
+	for _, i := range candidates {
+		if len(fields[i].index) == shallowest {
+			peers++
+			if fields[i].tagged {
+				tagged++
+			}
+		}
+	}
+	if peers == 1 {
+		return true
+	}
+	return f.tagged && tagged == 1
+}
+
+// implementsMarshaler reports whether t or *t encodes itself
+func implementsMarshaler(t reflect.Type) bool {
+	return marshalerKindOf(t) != marshalsNone
+}
+
+// tagOptions are the options following the name in a json tag
+type tagOptions string
+
+// parseJSONTag splits a json tag into the name and the options
+func parseJSONTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+// has reports whether the options contain option
+func (o tagOptions) has(option string) bool {
+	for s := string(o); s != ""; {
+		var next string
+		if i := strings.Index(s, ","); i >= 0 {
+			s, next = s[:i], s[i+1:]
+		}
+		if s == option {
+			return true
+		}
+		s = next
+	}
+	return false
+}
+
+// putStruct writes a struct as an object according to its cached plan
+func (p *JSONDataStream) putStruct(v reflect.Value) {
+	plan := cachedStructPlan(v.Type())
+	p.OpenObject()
+	for i := range plan.fields {
+		f := &plan.fields[i]
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if p.plain() {
+			p.beginValue()
+			p.writeArray(f.key)
+			p.comma = false
+			p.afterKey = true
+		} else {
+			p.putKeyString(f.name)
+		}
+		if f.quoted {
+			p.putQuoted(fv)
+		} else {
+			p.putReflect(fv)
+		}
+		if p.err != nil {
+			return
+		}
+	}
+	p.CloseObject()
+}
+
+// putQuoted writes a scalar as a JSON string, for the string tag option
+func (p *JSONDataStream) putQuoted(v reflect.Value) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			p.PutNull()
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.String {
+		inner := appendEscaped([]byte{'"'}, v.String(), false)
+		p.PutString(append(inner, '"'))
+		return
+	}
+	p.beginValue()
+	p.write('"')
+	p.afterKey = true // the quoted value needs no separator
+	p.putReflect(v)
+	p.afterKey = false
+	p.write('"')
+	p.comma = true
+}
+
+// fieldByIndex returns the field of v at the index path. It reports false if
+// the path goes through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyValue reports whether v is empty in the sense of the omitempty option
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2021 Miczone Asia.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type embedA struct {
+	Name string
+	A    int
+}
+
+type embedB struct {
+	Name string `json:"name"`
+	B    int
+}
+
+type embedTagged struct {
+	ID int `json:"id"`
+}
+
+type embedOuter struct {
+	embedA
+	Inner struct{ X int }
+}
+
+// The structs below exercise the rules deciding which of several fields with
+// the same name is encoded.
+type (
+	conflictSameDepth struct {
+		embedA
+		embedB
+	}
+	conflictShallower struct {
+		Name string
+		embedA
+	}
+	conflictTagWins struct {
+		embedB
+		Other struct{ Name string }
+		embedTagged
+	}
+	conflictTwice struct {
+		*embedA
+		Nested struct{ embedA }
+		embedOuter
+	}
+	conflictTwiceSameDepth struct {
+		Left struct{ embedA } `json:"-"`
+		embedOuter
+		embedC
+	}
+	embedC struct {
+		embedA
+	}
+)
+
+func TestStructPlanMatchesEncodingJSON(t *testing.T) {
+	values := []interface{}{
+		conflictSameDepth{embedA{"a", 1}, embedB{"b", 2}},
+		conflictShallower{"top", embedA{"a", 1}},
+		conflictTagWins{embedB: embedB{"b", 2}, embedTagged: embedTagged{3}},
+		conflictTwice{embedA: &embedA{"a", 1}, embedOuter: embedOuter{embedA: embedA{"o", 2}}},
+		conflictTwice{},
+		conflictTwiceSameDepth{embedOuter: embedOuter{embedA: embedA{"o", 1}}, embedC: embedC{embedA{"c", 2}}},
+	}
+	for _, v := range values {
+		want, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("%T: encoding/json failed: %v", v, err)
+		}
+		have, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("%T: %v", v, err)
+		}
+		if string(have) != string(want) {
+			t.Errorf("%T mismatch\nhave %s\nwant %s", v, have, want)
+		}
+	}
+}
+
+type benchAddress struct {
+	Street  string `json:"street"`
+	City    string `json:"city"`
+	Country string `json:"country,omitempty"`
+}
+
+type benchUser struct {
+	ID       int64             `json:"id"`
+	Name     string            `json:"name"`
+	Email    string            `json:"email,omitempty"`
+	Admin    bool              `json:"admin"`
+	Score    float64           `json:"score"`
+	Balance  int64             `json:"balance,string"`
+	Tags     []string          `json:"tags"`
+	Address  *benchAddress     `json:"address,omitempty"`
+	Settings map[string]string `json:"settings,omitempty"`
+	password string
+}
+
+func newBenchUsers(n int) []benchUser {
+	users := make([]benchUser, n)
+	for i := range users {
+		users[i] = benchUser{
+			ID:      int64(i),
+			Name:    "user \"quoted\" name",
+			Email:   "user@example.com",
+			Admin:   i%2 == 0,
+			Score:   float64(i) / 3,
+			Balance: int64(i) * 1000,
+			Tags:    []string{"a", "b", "c"},
+			Address: &benchAddress{Street: "1 Main St", City: "Hanoi"},
+		}
+	}
+	return users
+}
+
+func BenchmarkMarshalStruct(b *testing.B) {
+	users := newBenchUsers(100)
+	opts := &JSONOptions{Floats: FloatShortest}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalWithOptions(users, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalStructEncodingJSON(b *testing.B) {
+	users := newBenchUsers(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(users); err != nil {
+			b.Fatal(err)
+		}
+	}
+}